@@ -26,26 +26,33 @@ func setupLogLevel(l string) error {
 	return nil
 }
 
-func initConfig(c *cli.Context) *types.Config {
+func loadConfig(c *cli.Context) (*types.Config, error) {
 	config := &types.Config{}
 
 	if err := configor.Load(config, c.String("config")); err != nil {
-		log.Fatalf("[main] load config failed %v", err)
+		return nil, err
 	}
 
 	config.PrepareConfig(c)
+	return config, nil
+}
+
+func initConfig(c *cli.Context) *types.Config {
+	config, err := loadConfig(c)
+	if err != nil {
+		log.Fatalf("[main] load config failed %v", err)
+	}
 	return config
 }
 
 func serve(c *cli.Context) error {
-	if err := setupLogLevel(c.String("log-level")); err != nil {
+	config := initConfig(c)
+	if err := setupLogLevel(config.LogLevel); err != nil {
 		log.Fatal(err)
 	}
-
-	config := initConfig(c)
 	log.Debugf("[config] %v", config)
+	// pidfile is removed by agent when shutting down
 	utils.WritePid(config.PidFile)
-	defer os.Remove(config.PidFile)
 
 	watcher.InitMonitor()
 	go watcher.LogMonitor.Serve()
@@ -55,7 +62,12 @@ func serve(c *cli.Context) error {
 		log.Fatal(err)
 	}
 
-	go api.Serve(config.API.Addr)
+	// SIGHUP reload
+	agent.SetConfigLoader(func() (*types.Config, error) {
+		return loadConfig(c)
+	})
+
+	go api.Serve(config.API.Addr, agent)
 
 	if err := agent.Run(); err != nil {
 		log.Fatalf("Agent caught error %s", err)
@@ -135,6 +147,12 @@ func main() {
 				Usage:   "forward stdout out? yes/no",
 				EnvVars: []string{"ERU_AGENT_LOG_STDOUT"},
 			},
+			&cli.StringFlag{
+				Name:    "log-spool-dir",
+				Value:   "",
+				Usage:   "dir to spool logs when forward is unreachable",
+				EnvVars: []string{"ERU_AGENT_LOG_SPOOL_DIR"},
+			},
 			&cli.StringFlag{
 				Name:    "pidfile",
 				Value:   "",
@@ -2,8 +2,13 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"regexp"
 	"runtime/pprof"
+	"strconv"
+	"strings"
 
 	// enable profile
 	_ "net/http/pprof"
@@ -16,13 +21,22 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/bmizerany/pat"
+	"github.com/gorilla/websocket"
 )
 
 // JSON define a json
 type JSON map[string]interface{}
 
+// Agent provide status of agent
+type Agent interface {
+	LogForwards() map[string][]string
+	Restarts() map[string]types.RestartStatus
+	Reconciliation() types.Reconciliation
+}
+
 // Handler define handler
 type Handler struct {
+	agent Agent
 }
 
 // URL /version/
@@ -43,6 +57,46 @@ func (h *Handler) profile(w http.ResponseWriter, req *http.Request) {
 	json.NewEncoder(w).Encode(r)
 }
 
+// URL /forwards/
+// log forwards in use of each container, id can be a prefix
+func (h *Handler) forwards(w http.ResponseWriter, req *http.Request) {
+	ID := req.URL.Query().Get("id")
+	r := JSON{}
+	for containerID, forwards := range h.agent.LogForwards() {
+		if strings.HasPrefix(containerID, ID) {
+			r[containerID] = forwards
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(r)
+}
+
+// URL /restarts/
+// restarts of containers within crash loop window, id can be a prefix
+// crashloop=1 to get only those in crash loop
+func (h *Handler) restarts(w http.ResponseWriter, req *http.Request) {
+	ID := req.URL.Query().Get("id")
+	onlyLooping := req.URL.Query().Get("crashloop") == "1"
+	r := JSON{}
+	for containerID, status := range h.agent.Restarts() {
+		if strings.HasPrefix(containerID, ID) && (!onlyLooping || status.CrashLoop) {
+			r[containerID] = status
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(r)
+}
+
+// URL /reconcile/
+// result of last reconciliation between docker and core
+func (h *Handler) reconcile(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.agent.Reconciliation())
+}
+
 // URL /log/
 func (h *Handler) log(w http.ResponseWriter, req *http.Request) {
 	app := req.URL.Query().Get("app")
@@ -50,36 +104,110 @@ func (h *Handler) log(w http.ResponseWriter, req *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	// fuck httpie
-	w.WriteHeader(http.StatusOK)
-	if hijack, ok := w.(http.Hijacker); ok {
-		conn, buf, err := hijack.Hijack()
+	filter, tail, err := parseLogFilter(req.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(JSON{"error": err.Error()})
+		return
+	}
+	logConsumer := &types.LogConsumer{
+		ID:     coreutils.RandomString(8),
+		App:    app,
+		Filter: filter,
+		Tail:   tail,
+	}
+	// same subscription, transport decided by request
+	switch {
+	case websocket.IsWebSocketUpgrade(req):
+		stream, err := newWSStream(w, req)
+		if err != nil {
+			log.Errorf("[apiLog] upgrade failed %v", err)
+			return
+		}
+		logConsumer.Stream = stream
+		watcher.LogMonitor.ConsumerC <- logConsumer
+		log.Infof("[apiLog] %s %s log attached via websocket", app, logConsumer.ID)
+	case strings.Contains(req.Header.Get("Accept"), "text/event-stream"):
+		stream, err := newSSEStream(w)
 		if err != nil {
 			log.Errorf("[apiLog] connect failed %v", err)
 			return
 		}
-		logConsumer := &types.LogConsumer{
-			ID:  coreutils.RandomString(8),
-			App: app, Conn: conn, Buf: buf,
+		logConsumer.Stream = stream
+		watcher.LogMonitor.ConsumerC <- logConsumer
+		log.Infof("[apiLog] %s %s log attached via sse", app, logConsumer.ID)
+	default:
+		// fuck httpie
+		w.WriteHeader(http.StatusOK)
+		hijack, ok := w.(http.Hijacker)
+		if !ok {
+			return
+		}
+		conn, buf, err := hijack.Hijack()
+		if err != nil {
+			log.Errorf("[apiLog] connect failed %v", err)
+			return
 		}
+		logConsumer.Stream = newChunkedStream(conn, buf)
 		watcher.LogMonitor.ConsumerC <- logConsumer
 		log.Infof("[apiLog] %s %s log attached", app, logConsumer.ID)
 	}
 }
 
+func parseLogFilter(query url.Values) (types.LogFilter, int, error) {
+	filter := types.LogFilter{
+		EntryPoint: query.Get("entrypoint"),
+		Ident:      query.Get("ident"),
+		Type:       query.Get("type"),
+		ID:         query.Get("id"),
+		Extra:      map[string]string{},
+	}
+	if filter.Type != "" && filter.Type != "stdout" && filter.Type != "stderr" {
+		return filter, 0, fmt.Errorf("invalid type %s", filter.Type)
+	}
+	if grep := query.Get("grep"); grep != "" {
+		re, err := regexp.Compile(grep)
+		if err != nil {
+			// not a regex, take it as substring
+			re = regexp.MustCompile(regexp.QuoteMeta(grep))
+		}
+		filter.Grep = re
+	}
+	for k := range query {
+		if strings.HasPrefix(k, "extra.") {
+			filter.Extra[strings.TrimPrefix(k, "extra.")] = query.Get(k)
+		}
+	}
+	tail := 0
+	if v := query.Get("tail"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return filter, 0, fmt.Errorf("invalid tail %s", v)
+		}
+		if n > watcher.RingSize {
+			n = watcher.RingSize
+		}
+		tail = n
+	}
+	return filter, tail, nil
+}
+
 // Serve start a api service
-func Serve(addr string) {
+func Serve(addr string, agent Agent) {
 	if addr == "" {
 		return
 	}
 
-	h := &Handler{}
+	h := &Handler{agent: agent}
 	restfulAPIServer := pat.New()
 	handlers := map[string]map[string]func(http.ResponseWriter, *http.Request){
 		"GET": {
-			"/profile/": h.profile,
-			"/version/": h.version,
-			"/log/":     h.log,
+			"/profile/":   h.profile,
+			"/version/":   h.version,
+			"/log/":       h.log,
+			"/forwards/":  h.forwards,
+			"/restarts/":  h.restarts,
+			"/reconcile/": h.reconcile,
 		},
 	}
 
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/projecteru2/agent/types"
+)
+
+type mockAgent struct{}
+
+func (a mockAgent) LogForwards() map[string][]string {
+	return map[string][]string{
+		"abc": {"tcp://127.0.0.1:5144"},
+		"def": {"journal://system", "tcp://127.0.0.1:5144"},
+	}
+}
+
+func (a mockAgent) Restarts() map[string]types.RestartStatus {
+	return map[string]types.RestartStatus{
+		"abc": {Restarts: 1, ExitCodes: []int{0}},
+		"def": {CrashLoop: true, Restarts: 5, ExitCodes: []int{1, 1, 1, 1, 137}},
+	}
+}
+
+func (a mockAgent) Reconciliation() types.Reconciliation {
+	return types.Reconciliation{Repushed: []string{"abc"}, DockerOrphans: []string{}, CoreOrphans: []string{"ghi"}}
+}
+
+func TestForwards(t *testing.T) {
+	h := &Handler{agent: mockAgent{}}
+	server := httptest.NewServer(http.HandlerFunc(h.forwards))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/forwards/?id=de")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	r := map[string][]string{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&r))
+	assert.Equal(t, map[string][]string{"def": {"journal://system", "tcp://127.0.0.1:5144"}}, r)
+}
+
+func TestRestarts(t *testing.T) {
+	h := &Handler{agent: mockAgent{}}
+	server := httptest.NewServer(http.HandlerFunc(h.restarts))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/restarts/?crashloop=1")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	r := map[string]types.RestartStatus{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&r))
+	assert.Equal(t, map[string]types.RestartStatus{"def": {CrashLoop: true, Restarts: 5, ExitCodes: []int{1, 1, 1, 1, 137}}}, r)
+}
+
+func TestReconcile(t *testing.T) {
+	h := &Handler{agent: mockAgent{}}
+	server := httptest.NewServer(http.HandlerFunc(h.reconcile))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/reconcile/")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	r := types.Reconciliation{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&r))
+	assert.Equal(t, []string{"ghi"}, r.CoreOrphans)
+	assert.Equal(t, []string{"abc"}, r.Repushed)
+}
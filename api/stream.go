@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeTimeout = 10 * time.Second
+	// peer must answer ping in time
+	pongTimeout = 90 * time.Second
+)
+
+// hijackedStream write on hijacked conn, so every write can be bounded by deadline
+type hijackedStream struct {
+	conn net.Conn
+	buf  *bufio.ReadWriter
+	done chan struct{}
+}
+
+func newHijackedStream(conn net.Conn, buf *bufio.ReadWriter) *hijackedStream {
+	s := &hijackedStream{conn: conn, buf: buf, done: make(chan struct{})}
+	go func() {
+		// peer sends nothing, read returns when conn closed
+		io.Copy(ioutil.Discard, buf)
+		close(s.done)
+	}()
+	return s
+}
+
+// write fails if peer doesn't read in time, so consumer gets detached
+func (s *hijackedStream) write(data string) error {
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := s.buf.WriteString(data); err != nil {
+		return err
+	}
+	return s.buf.Flush()
+}
+
+func (s *hijackedStream) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *hijackedStream) Close() error {
+	return s.conn.Close()
+}
+
+// chunkedStream write chunked http response on hijacked conn
+type chunkedStream struct {
+	*hijackedStream
+}
+
+func newChunkedStream(conn net.Conn, buf *bufio.ReadWriter) *chunkedStream {
+	return &chunkedStream{newHijackedStream(conn, buf)}
+}
+
+// Send one line per chunk
+func (s *chunkedStream) Send(data []byte) error {
+	return s.write(fmt.Sprintf("%X\r\n%s\r\n\r\n", len(data)+2, data))
+}
+
+// Heartbeat do nothing, legacy clients don't expect anything but log lines
+// broken conn is found by next Send
+func (s *chunkedStream) Heartbeat() error {
+	return nil
+}
+
+// sseStream write server-sent events on hijacked conn
+// response is ended by closing conn
+type sseStream struct {
+	*hijackedStream
+}
+
+func newSSEStream(w http.ResponseWriter) (*sseStream, error) {
+	hijack, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, fmt.Errorf("streaming unsupported")
+	}
+	conn, buf, err := hijack.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	s := &sseStream{newHijackedStream(conn, buf)}
+	header := "HTTP/1.1 200 OK\r\n" +
+		"Content-Type: text/event-stream\r\n" +
+		"Cache-Control: no-cache\r\n" +
+		"Connection: close\r\n\r\n"
+	if err := s.write(header); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Send a log as data event
+func (s *sseStream) Send(data []byte) error {
+	return s.write(fmt.Sprintf("data: %s\n\n", data))
+}
+
+// Heartbeat send a comment
+func (s *sseStream) Heartbeat() error {
+	return s.write(": ping\n\n")
+}
+
+// wsStream write websocket messages
+type wsStream struct {
+	conn *websocket.Conn
+	done chan struct{}
+}
+
+// default same origin check applies, browsers of other sites can't read logs
+var upgrader = websocket.Upgrader{}
+
+func newWSStream(w http.ResponseWriter, req *http.Request) (*wsStream, error) {
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return nil, err
+	}
+	s := &wsStream{conn: conn, done: make(chan struct{})}
+	conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+	go func() {
+		// handle control frames, returns on close frame, error or pong timeout
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				close(s.done)
+				return
+			}
+		}
+	}()
+	return s, nil
+}
+
+// Send a log as text message
+func (s *wsStream) Send(data []byte) error {
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return s.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// Heartbeat send ping, peer not answering will be detected by read deadline
+func (s *wsStream) Heartbeat() error {
+	return s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+}
+
+func (s *wsStream) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *wsStream) Close() error {
+	s.conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(writeTimeout),
+	)
+	return s.conn.Close()
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/projecteru2/agent/types"
+	"github.com/projecteru2/agent/watcher"
+)
+
+func init() {
+	watcher.InitMonitor()
+	go watcher.LogMonitor.Serve()
+}
+
+// keep sending until subscriber attached
+func publish(done chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case watcher.LogMonitor.LogC <- &types.Log{Name: "app", Type: "stdout", Data: "hello"}:
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestLogStreamWebSocket(t *testing.T) {
+	h := &Handler{}
+	server := httptest.NewServer(http.HandlerFunc(h.log))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/log/?app=app&type=stdout"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go publish(done)
+
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, data, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"data":"hello"`)
+}
+
+func TestLogStreamSSE(t *testing.T) {
+	h := &Handler{}
+	server := httptest.NewServer(http.HandlerFunc(h.log))
+	defer server.Close()
+
+	req, err := http.NewRequest("GET", server.URL+"/log/?app=app", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	done := make(chan struct{})
+	defer close(done)
+	go publish(done)
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(line, "data: {"))
+}
+
+func TestLogStreamBadRequest(t *testing.T) {
+	h := &Handler{}
+	server := httptest.NewServer(http.HandlerFunc(h.log))
+	defer server.Close()
+
+	for _, query := range []string{"", "?app=app&type=x", "?app=app&tail=-1"} {
+		resp, err := http.Get(server.URL + "/log/" + query)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp.Body.Close()
+	}
+}
+
+func TestLogStreamWebSocketCrossOrigin(t *testing.T) {
+	h := &Handler{}
+	server := httptest.NewServer(http.HandlerFunc(h.log))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/log/?app=app"
+	header := http.Header{"Origin": {"http://evil.example.com"}}
+	_, resp, err := websocket.DefaultDialer.Dial(url, header)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
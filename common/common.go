@@ -13,10 +13,43 @@ const (
 	StatusStart = "start"
 	// StatusDestory for destory status
 	StatusDestory = "destroy"
+	// StatusOOM for oom status
+	StatusOOM = "oom"
+	// StatusKill for kill status
+	StatusKill = "kill"
+	// StatusPause for pause status
+	StatusPause = "pause"
+	// StatusUnpause for unpause status
+	StatusUnpause = "unpause"
+	// StatusHealth for docker health check status, action is like "health_status: healthy"
+	StatusHealth = "health_status"
+	// StatusRename for rename status
+	StatusRename = "rename"
 
 	// DateTimeFormat for datetime format
 	DateTimeFormat = "2006-01-02 15:04:05.999999"
 
 	// DOCKERIZED detect agent in docker
 	DOCKERIZED = "AGENT_IN_DOCKER"
+
+	// LabelLogMultilineStart for regex matching the first line of a multiline log
+	LabelLogMultilineStart = "eru.log.multiline.start"
+	// LabelLogMultilineContinue for regex matching the following lines of a multiline log
+	LabelLogMultilineContinue = "eru.log.multiline.continue"
+	// LabelLogMultilineFlushTimeout for how long to wait before flushing a multiline log
+	LabelLogMultilineFlushTimeout = "eru.log.multiline.flush_timeout"
+
+	// LabelLogParse for parsing structured log, json, logfmt or auto
+	LabelLogParse = "eru.log.parse"
+	// LabelLogParseKeys for keys lifted into extra, split by comma
+	LabelLogParseKeys = "eru.log.parse.keys"
+	// LabelLogParseStrip for removing lifted keys from data
+	LabelLogParseStrip = "eru.log.parse.strip"
+
+	// LabelLogRate for lines per second allowed to forward
+	LabelLogRate = "eru.log.rate"
+	// LabelLogBurst for lines allowed to forward in a burst
+	LabelLogBurst = "eru.log.burst"
+	// LabelLogSample for keeping 1 of N lines over rate
+	LabelLogSample = "eru.log.sample"
 )
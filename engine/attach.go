@@ -3,9 +3,12 @@ package engine
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"io"
 	"net/http/httputil"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/pkg/stdcopy"
@@ -17,24 +20,42 @@ import (
 	"github.com/projecteru2/agent/common"
 	"github.com/projecteru2/agent/engine/logs"
 	"github.com/projecteru2/agent/types"
+	"github.com/projecteru2/agent/utils"
 	"github.com/projecteru2/agent/watcher"
 )
 
 func (e *Engine) attach(container *types.Container) {
-	transfer := e.forwards.Get(container.ID, 0)
-	if transfer == "" {
-		transfer = logs.Discard
+	if e.ctx.Err() != nil {
+		// shutting down
+		return
+	}
+	if e.isAttached(container.ID) {
+		// resynced after events reconnected, or start event seen twice
+		log.Debugf("[attach] %s container %s already attached", container.Name, coreutils.ShortID(container.ID))
+		return
 	}
-	writer, err := logs.NewWriter(transfer, e.config.Log.Stdout)
+	writer, err := logs.NewTee(e.logForwards(container), container.ID, e.logConfig())
 	if err != nil {
 		log.Errorf("[attach] Create log forward failed %s", err)
 		return
 	}
+	ctx := context.Background()
+	cancelCtx, cancel := context.WithCancel(ctx)
+	lw, ok := e.setWriter(container, writer, cancel)
+	if !ok {
+		// attached concurrently
+		cancel()
+		writer.Close()
+		return
+	}
+	e.attached.Add(1)
+
+	parser := logParser(container)
+	rateLimit := rateLimitConfig(container, e.config.Log.RateLimit)
+	limiter := logs.NewLimiter(rateLimit, container.Name)
 
 	outr, outw := io.Pipe()
 	errr, errw := io.Pipe()
-	ctx := context.Background()
-	cancelCtx, cancel := context.WithCancel(ctx)
 	go func() {
 		options := dockertypes.ContainerAttachOptions{
 			Stream: true,
@@ -42,15 +63,25 @@ func (e *Engine) attach(container *types.Container) {
 			Stdout: true,
 			Stderr: true,
 		}
+		defer outw.Close()
+		defer errw.Close()
+		defer cancel()
 		resp, err := e.docker.ContainerAttach(ctx, container.ID, options)
 		if err != nil && err != httputil.ErrPersistEOF {
 			log.Errorf("[attach] attach %s container %s failed %s", container.Name, coreutils.ShortID(container.ID), err)
 			return
 		}
 		defer resp.Close()
-		defer outw.Close()
-		defer errw.Close()
-		defer cancel()
+		go func() {
+			select {
+			case <-e.ctx.Done():
+				// shutting down, stop streaming and let pumps drain
+				resp.Close()
+			case <-cancelCtx.Done():
+				// finished, or destroyed
+				resp.Close()
+			}
+		}()
 		_, err = stdcopy.StdCopy(outw, errw, resp.Reader)
 		if err != nil {
 			log.Errorf("[attach] attach get stream failed %s", err)
@@ -59,12 +90,85 @@ func (e *Engine) attach(container *types.Container) {
 	}()
 	log.Infof("[attach] attach %s container %s success", container.Name, coreutils.ShortID(container.ID))
 	// attach metrics
-	go e.stat(cancelCtx, container)
+	e.attached.Add(1)
+	go func() {
+		defer e.attached.Done()
+		e.stat(cancelCtx, container)
+	}()
+	newLog := func(typ, data string) *types.Log {
+		// may be renamed
+		name, entrypoint, ident := lw.appInfo()
+		return &types.Log{
+			ID:         container.ID,
+			Name:       name,
+			Type:       typ,
+			EntryPoint: entrypoint,
+			Ident:      ident,
+			Data:       data,
+			Datetime:   time.Now().Format(common.DateTimeFormat),
+		}
+	}
+	send := func(l *types.Log) {
+		// api subscribers must not block forwarding
+		select {
+		case watcher.LogMonitor.LogC <- l:
+		default:
+			watcher.LogMonitor.Drop(l)
+		}
+		if err := writer.Write(l); err != nil && !(container.EntryPoint == "agent" && e.dockerized) {
+			log.Errorf("[attach] %s container %s_%s write failed %v", container.Name, container.EntryPoint, coreutils.ShortID(container.ID), err)
+			log.Errorf("[attach] %s", l.Data)
+		}
+	}
+	// writer is closed after pumps and reporter finished
+	var wg sync.WaitGroup
+	if limiter != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tick := time.NewTicker(rateLimit.ReportInterval)
+			defer tick.Stop()
+			for {
+				select {
+				case <-tick.C:
+				case <-cancelCtx.Done():
+				}
+				if dropped := limiter.Dropped(); dropped > 0 {
+					send(newLog("agent", fmt.Sprintf("%d lines dropped by rate limit", dropped)))
+				}
+				if cancelCtx.Err() != nil {
+					return
+				}
+			}
+		}()
+	}
 	pump := func(typ string, source io.Reader) {
+		emit := func(data string) {
+			if limiter != nil && !limiter.Allow() {
+				return
+			}
+			l := newLog(typ, data)
+			if parser != nil {
+				l.Extra, l.Data = parser.Parse(data)
+			}
+			send(l)
+		}
+		var multiline *logs.Multiline
+		if config := multilineConfig(container, e.config.Log.Multiline); config != nil {
+			m, err := logs.NewMultiline(*config, emit)
+			if err != nil {
+				log.Errorf("[attach] %s container %s multiline disabled %s", container.Name, coreutils.ShortID(container.ID), err)
+			} else {
+				multiline = m
+			}
+		}
 		buf := bufio.NewReader(source)
 		for {
 			data, err := buf.ReadString('\n')
 			if err != nil {
+				if multiline != nil {
+					multiline.Flush()
+				}
 				if err != io.EOF {
 					log.Errorf("[attach] attach pump %s %s %s %s", container.Name, coreutils.ShortID(container.ID), typ, err)
 				}
@@ -72,24 +176,251 @@ func (e *Engine) attach(container *types.Container) {
 			}
 			data = strings.TrimSuffix(data, "\n")
 			data = strings.TrimSuffix(data, "\r")
-			l := &types.Log{
-				ID:         container.ID,
-				Name:       container.Name,
-				Type:       typ,
-				EntryPoint: container.EntryPoint,
-				Ident:      container.Ident,
-				Data:       data,
-				Datetime:   time.Now().Format(common.DateTimeFormat),
-				//TODO
-				//Extra
+			if multiline != nil {
+				multiline.Feed(data)
+				continue
 			}
-			watcher.LogMonitor.LogC <- l
-			if err := writer.Write(l); err != nil && !(container.EntryPoint == "agent" && e.dockerized) {
-				log.Errorf("[attach] %s container %s_%s write failed %v", container.Name, container.EntryPoint, coreutils.ShortID(container.ID), err)
-				log.Errorf("[attach] %s", data)
+			emit(data)
+		}
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		pump("stdout", outr)
+	}()
+	go func() {
+		defer wg.Done()
+		pump("stderr", errr)
+	}()
+	go func() {
+		wg.Wait()
+		e.removeWriter(container.ID, writer)
+		writer.Close()
+		if lw.isDestroyed() {
+			if err := logs.RemoveSpool(e.config.Log.Spool, container.ID); err != nil {
+				log.Errorf("[attach] remove spool of %s failed %s", coreutils.ShortID(container.ID), err)
+			}
+		}
+		e.attached.Done()
+	}()
+}
+
+// logWriter is log writer of an attached container
+type logWriter struct {
+	*logs.Tee
+	sync.RWMutex
+	container  *types.Container
+	name       string
+	entrypoint string
+	ident      string
+	destroyed  bool
+	stop       context.CancelFunc
+}
+
+func (w *logWriter) appInfo() (string, string, string) {
+	w.RLock()
+	defer w.RUnlock()
+	return w.name, w.entrypoint, w.ident
+}
+
+func (w *logWriter) rename(name, entrypoint, ident string) {
+	w.Lock()
+	defer w.Unlock()
+	w.name, w.entrypoint, w.ident = name, entrypoint, ident
+}
+
+// snapshot return container with current name
+func (w *logWriter) snapshot() *types.Container {
+	w.RLock()
+	defer w.RUnlock()
+	container := *w.container
+	container.Name, container.EntryPoint, container.Ident = w.name, w.entrypoint, w.ident
+	return &container
+}
+
+// destroy stop attaching, spool will be removed after writer closed
+func (w *logWriter) destroy() {
+	w.Lock()
+	w.destroyed = true
+	w.Unlock()
+	w.stop()
+}
+
+func (w *logWriter) isDestroyed() bool {
+	w.RLock()
+	defer w.RUnlock()
+	return w.destroyed
+}
+
+// LogForwards return forwards in use of each attached container
+func (e *Engine) LogForwards() map[string][]string {
+	e.writersMu.Lock()
+	defer e.writersMu.Unlock()
+	forwards := map[string][]string{}
+	for ID, writer := range e.writers {
+		forwards[ID] = writer.Forwards()
+	}
+	return forwards
+}
+
+func (e *Engine) isAttached(ID string) bool {
+	e.writersMu.Lock()
+	defer e.writersMu.Unlock()
+	_, ok := e.writers[ID]
+	return ok
+}
+
+// setWriter return false if container is attached already
+func (e *Engine) setWriter(container *types.Container, writer *logs.Tee, stop context.CancelFunc) (*logWriter, bool) {
+	e.writersMu.Lock()
+	defer e.writersMu.Unlock()
+	if e.writers == nil {
+		e.writers = map[string]*logWriter{}
+	}
+	if _, ok := e.writers[container.ID]; ok {
+		return nil, false
+	}
+	w := &logWriter{
+		Tee:        writer,
+		container:  container,
+		name:       container.Name,
+		entrypoint: container.EntryPoint,
+		ident:      container.Ident,
+		stop:       stop,
+	}
+	e.writers[container.ID] = w
+	return w, true
+}
+
+func (e *Engine) getWriter(ID string) *logWriter {
+	e.writersMu.Lock()
+	defer e.writersMu.Unlock()
+	return e.writers[ID]
+}
+
+func (e *Engine) removeWriter(ID string, writer *logs.Tee) {
+	e.writersMu.Lock()
+	defer e.writersMu.Unlock()
+	if w, ok := e.writers[ID]; ok && w.Tee == writer {
+		delete(e.writers, ID)
+	}
+}
+
+// logForwards return forwards of matched routes, each with its fail over candidates
+// hashed forward is used if no route matched
+func (e *Engine) logForwards(container *types.Container) [][]string {
+	forwards := [][]string{}
+	seen := []string{}
+	matched, useDefault := false, false
+	for _, route := range e.config.Log.Routes {
+		if !matchRoute(container, route) {
+			continue
+		}
+		matched = true
+		useDefault = useDefault || route.Default
+		for _, forward := range route.Forwards {
+			if !utils.InStringSlice(seen, forward) {
+				seen = append(seen, forward)
+				forwards = append(forwards, []string{forward})
 			}
 		}
 	}
-	go pump("stdout", outr)
-	go pump("stderr", errr)
+	if !matched || useDefault {
+		// walk the hash ring for fail over
+		candidates := []string{}
+		backends := e.logBackends()
+		for i := 0; i < int(backends.Len()); i++ {
+			candidates = append(candidates, backends.Get(container.ID, i))
+		}
+		switch {
+		case len(candidates) > 0 && !utils.InStringSlice(seen, candidates[0]):
+			forwards = append(forwards, candidates)
+		case len(forwards) == 0:
+			forwards = append(forwards, []string{logs.Discard})
+		}
+	}
+	return forwards
+}
+
+func matchRoute(container *types.Container, route types.RouteConfig) bool {
+	if len(route.Apps) > 0 && !utils.InStringSlice(route.Apps, container.Name) {
+		return false
+	}
+	if len(route.EntryPoints) > 0 && !utils.InStringSlice(route.EntryPoints, container.EntryPoint) {
+		return false
+	}
+	return coreutils.FilterContainer(container.Labels, route.Labels)
+}
+
+// label 优先于配置, 日志相关的 label 都是这样
+func multilineConfig(container *types.Container, configs map[string]types.MultilineConfig) *types.MultilineConfig {
+	config := configs[container.Name]
+	if start, ok := container.Labels[common.LabelLogMultilineStart]; ok {
+		config.Start = start
+	}
+	if cont, ok := container.Labels[common.LabelLogMultilineContinue]; ok {
+		config.Continue = cont
+	}
+	if timeout, ok := container.Labels[common.LabelLogMultilineFlushTimeout]; ok {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			log.Errorf("[multilineConfig] invalid flush timeout %s %s", timeout, err)
+		} else {
+			config.FlushTimeout = d
+		}
+	}
+	if config.Start == "" && config.Continue == "" {
+		return nil
+	}
+	return &config
+}
+
+func logParser(container *types.Container) *logs.Parser {
+	format, ok := container.Labels[common.LabelLogParse]
+	if !ok {
+		return nil
+	}
+	keys := []string{}
+	if v := container.Labels[common.LabelLogParseKeys]; v != "" {
+		keys = strings.Split(v, ",")
+	}
+	strip, _ := strconv.ParseBool(container.Labels[common.LabelLogParseStrip])
+	parser, err := logs.NewParser(format, keys, strip)
+	if err != nil {
+		log.Errorf("[logParser] %s container %s parser disabled %s", container.Name, coreutils.ShortID(container.ID), err)
+		return nil
+	}
+	return parser
+}
+
+// bad label is ignored, config is used then
+func rateLimitConfig(container *types.Container, config types.RateLimitConfig) types.RateLimitConfig {
+	invalid := func(label, v string, err error) {
+		log.Warnf("[rateLimitConfig] container %s invalid %s %s %s", coreutils.ShortID(container.ID), label, v, err)
+	}
+	if v, ok := container.Labels[common.LabelLogRate]; ok {
+		if rate, err := strconv.ParseFloat(v, 64); err != nil {
+			invalid(common.LabelLogRate, v, err)
+		} else {
+			config.Rate = rate
+		}
+	}
+	if v, ok := container.Labels[common.LabelLogBurst]; ok {
+		if burst, err := strconv.Atoi(v); err != nil {
+			invalid(common.LabelLogBurst, v, err)
+		} else {
+			config.Burst = burst
+		}
+	}
+	if v, ok := container.Labels[common.LabelLogSample]; ok {
+		if sample, err := strconv.Atoi(v); err != nil {
+			invalid(common.LabelLogSample, v, err)
+		} else {
+			config.Sample = sample
+		}
+	}
+	if config.ReportInterval <= 0 {
+		config.ReportInterval = 10 * time.Second
+	}
+	return config
 }
@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/projecteru2/agent/common"
+	"github.com/projecteru2/agent/engine/logs"
+	"github.com/projecteru2/agent/types"
+	"github.com/projecteru2/agent/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultilineConfig(t *testing.T) {
+	configs := map[string]types.MultilineConfig{
+		"java": {Start: `^\d`, FlushTimeout: time.Second},
+	}
+	container := &types.Container{Name: "python", Labels: map[string]string{}}
+	assert.Nil(t, multilineConfig(container, configs))
+
+	container.Name = "java"
+	config := multilineConfig(container, configs)
+	assert.Equal(t, `^\d`, config.Start)
+
+	container.Labels[common.LabelLogMultilineContinue] = `^\s`
+	container.Labels[common.LabelLogMultilineFlushTimeout] = "200ms"
+	config = multilineConfig(container, configs)
+	assert.Equal(t, `^\d`, config.Start)
+	assert.Equal(t, `^\s`, config.Continue)
+	assert.Equal(t, 200*time.Millisecond, config.FlushTimeout)
+}
+
+func TestLogParser(t *testing.T) {
+	container := &types.Container{Labels: map[string]string{}}
+	assert.Nil(t, logParser(container))
+
+	container.Labels[common.LabelLogParse] = "yaml"
+	assert.Nil(t, logParser(container))
+
+	container.Labels[common.LabelLogParse] = "json"
+	container.Labels[common.LabelLogParseKeys] = "level,code"
+	container.Labels[common.LabelLogParseStrip] = "true"
+	extra, data := logParser(container).Parse(`{"code":1,"level":"info","msg":"hi"}`)
+	assert.Equal(t, map[string]string{"code": "1", "level": "info"}, extra)
+	assert.Equal(t, `{"msg":"hi"}`, data)
+}
+
+func TestRateLimitConfig(t *testing.T) {
+	container := &types.Container{Labels: map[string]string{}}
+	config := rateLimitConfig(container, types.RateLimitConfig{Rate: 100, Burst: 200})
+	assert.Equal(t, 100.0, config.Rate)
+	assert.Equal(t, 10*time.Second, config.ReportInterval)
+
+	container.Labels[common.LabelLogRate] = "0.5"
+	container.Labels[common.LabelLogSample] = "10"
+	config = rateLimitConfig(container, types.RateLimitConfig{Rate: 100, Burst: 200})
+	assert.Equal(t, 0.5, config.Rate)
+	assert.Equal(t, 200, config.Burst)
+	assert.Equal(t, 10, config.Sample)
+
+	// bad one is ignored
+	container.Labels[common.LabelLogBurst] = "many"
+	config = rateLimitConfig(container, types.RateLimitConfig{Rate: 100, Burst: 200})
+	assert.Equal(t, 200, config.Burst)
+}
+
+func TestLogForwards(t *testing.T) {
+	e := &Engine{config: &types.Config{}, forwards: utils.NewHashBackends([]string{"udp://127.0.0.1:5144"})}
+	container := &types.Container{Name: "app", EntryPoint: "web", Labels: map[string]string{"team": "infra"}}
+	assert.Equal(t, [][]string{{"udp://127.0.0.1:5144"}}, e.logForwards(container))
+
+	e.config.Log.Routes = []types.RouteConfig{
+		{Apps: []string{"app"}, EntryPoints: []string{"web"}, Forwards: []string{"tcp://collector:5144"}},
+		{Labels: map[string]string{"team": "infra"}, Forwards: []string{"journal://system", "tcp://collector:5144"}},
+		{Apps: []string{"other"}, Forwards: []string{"tcp://other:5144"}},
+	}
+	assert.Equal(t, [][]string{{"tcp://collector:5144"}, {"journal://system"}}, e.logForwards(container))
+
+	e.config.Log.Routes[0].Default = true
+	e.forwards = utils.NewHashBackends([]string{"udp://127.0.0.1:5144", "udp://127.0.0.1:5145"})
+	forwards := e.logForwards(container)
+	assert.Len(t, forwards, 3)
+	assert.ElementsMatch(t, []string{"udp://127.0.0.1:5144", "udp://127.0.0.1:5145"}, forwards[2])
+
+	container.Name = "nobody"
+	container.Labels = map[string]string{}
+	e.forwards = utils.NewHashBackends([]string{})
+	assert.Equal(t, [][]string{{logs.Discard}}, e.logForwards(container))
+}
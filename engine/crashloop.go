@@ -0,0 +1,228 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/projecteru2/agent/types"
+	coreutils "github.com/projecteru2/core/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	crashLoops = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "container_crashloop",
+		Help: "containers in crash loop.",
+	}, []string{"appname", "entrypoint"})
+	crashLoopEntered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "container_crashloop_entered",
+		Help: "times containers entered crash loop.",
+	}, []string{"appname", "entrypoint"})
+)
+
+func init() {
+	prometheus.MustRegister(crashLoops, crashLoopEntered)
+}
+
+// death within it after killed is taken as stopped by user
+// signals not stopping container, e.g. SIGHUP, are forgotten after it
+const maxStopWait = time.Minute
+
+// restart history of a container
+type restartHistory struct {
+	name       string
+	entrypoint string
+	deaths     []death
+	startedAt  time.Time
+	looping    bool
+	// killed by user, death soon after doesn't count
+	killedAt time.Time
+	// labels of gauge when entered, container may be renamed since
+	loopLabels []string
+}
+
+type death struct {
+	at       time.Time
+	exitCode int
+}
+
+// prune forget deaths out of window
+func (h *restartHistory) prune(now time.Time, window time.Duration) {
+	i := 0
+	for ; i < len(h.deaths); i++ {
+		if now.Sub(h.deaths[i].at) < window {
+			break
+		}
+	}
+	h.deaths = h.deaths[i:]
+}
+
+func (h *restartHistory) status() types.RestartStatus {
+	exitCodes := []int{}
+	for _, d := range h.deaths {
+		exitCodes = append(exitCodes, d.exitCode)
+	}
+	return types.RestartStatus{CrashLoop: h.looping, Restarts: len(h.deaths), ExitCodes: exitCodes}
+}
+
+func (e *Engine) history(container *types.Container) *restartHistory {
+	if e.restarts == nil {
+		e.restarts = map[string]*restartHistory{}
+	}
+	h, ok := e.restarts[container.ID]
+	if !ok {
+		h = &restartHistory{}
+		e.restarts[container.ID] = h
+	}
+	h.name, h.entrypoint = container.Name, container.EntryPoint
+	return h
+}
+
+// recordStart remember when container started
+// a looping container leaves crash loop after running for a whole window
+func (e *Engine) recordStart(container *types.Container) {
+	config := e.config.CrashLoop
+	if config.Restarts <= 0 {
+		return
+	}
+	e.restartsMu.Lock()
+	defer e.restartsMu.Unlock()
+	h := e.history(container)
+	h.startedAt = time.Now()
+	h.killedAt = time.Time{}
+	if h.looping {
+		startedAt := h.startedAt
+		time.AfterFunc(config.Window, func() { e.checkRecovered(container.ID, startedAt) })
+	}
+}
+
+// recordDie remember container died
+// restart backoff makes deaths sparse, so looping is kept until container runs stably
+func (e *Engine) recordDie(container *types.Container, exitCode int) {
+	config := e.config.CrashLoop
+	if config.Restarts <= 0 {
+		return
+	}
+	e.restartsMu.Lock()
+	defer e.restartsMu.Unlock()
+	h := e.history(container)
+	now := time.Now()
+	if !h.killedAt.IsZero() && now.Sub(h.killedAt) < maxStopWait {
+		// docker stop or kill, not a crash
+		h.killedAt = time.Time{}
+		h.startedAt = time.Time{}
+		return
+	}
+	if !h.startedAt.IsZero() && now.Sub(h.startedAt) >= config.Window {
+		// ran stably before this death
+		h.deaths = nil
+		e.leaveCrashLoop(container.ID, h)
+	}
+	h.prune(now, config.Window)
+	h.deaths = append(h.deaths, death{at: now, exitCode: exitCode})
+	h.startedAt = time.Time{}
+	if h.looping || len(h.deaths) < config.Restarts {
+		return
+	}
+	h.looping = true
+	h.loopLabels = []string{h.name, h.entrypoint}
+	crashLoops.WithLabelValues(h.loopLabels...).Inc()
+	crashLoopEntered.WithLabelValues(h.name, h.entrypoint).Inc()
+	log.Warnf("[crashloop] Container %s %s entered crash loop, %d restarts within %v, exit codes %v", h.name, coreutils.ShortID(container.ID), len(h.deaths), config.Window, h.status().ExitCodes)
+}
+
+// recordKill remember container is being killed by user
+// kill event comes before die, so the death is skipped
+func (e *Engine) recordKill(ID string) {
+	if e.config.CrashLoop.Restarts <= 0 {
+		return
+	}
+	e.restartsMu.Lock()
+	defer e.restartsMu.Unlock()
+	if e.restarts == nil {
+		e.restarts = map[string]*restartHistory{}
+	}
+	h, ok := e.restarts[ID]
+	if !ok {
+		h = &restartHistory{}
+		e.restarts[ID] = h
+	}
+	h.killedAt = time.Now()
+}
+
+// leaveCrashLoop must be called with restartsMu held
+func (e *Engine) leaveCrashLoop(ID string, h *restartHistory) {
+	if !h.looping {
+		return
+	}
+	h.looping = false
+	crashLoops.WithLabelValues(h.loopLabels...).Dec()
+	log.Infof("[crashloop] Container %s %s left crash loop", h.name, coreutils.ShortID(ID))
+}
+
+// checkRecovered called after container running for a whole window
+func (e *Engine) checkRecovered(ID string, startedAt time.Time) {
+	if e.ctx.Err() != nil {
+		return
+	}
+	e.restartsMu.Lock()
+	h, ok := e.restarts[ID]
+	recovered := ok && h.looping && h.startedAt.Equal(startedAt)
+	if recovered {
+		h.deaths = nil
+		e.leaveCrashLoop(ID, h)
+	}
+	e.restartsMu.Unlock()
+	if !recovered {
+		return
+	}
+
+	container, err := e.detectContainer(ID)
+	if err != nil {
+		log.Errorf("[checkRecovered] detect container failed %v", err)
+		return
+	}
+	e.setContainerStatus(container)
+}
+
+// markCrashLoop fill restarts of container, crash looping one is not healthy
+func (e *Engine) markCrashLoop(container *types.Container) {
+	e.restartsMu.Lock()
+	defer e.restartsMu.Unlock()
+	h, ok := e.restarts[container.ID]
+	if !ok {
+		return
+	}
+	h.prune(time.Now(), e.config.CrashLoop.Window)
+	container.CrashLoop = h.looping
+	container.Restarts = len(h.deaths)
+	if h.looping {
+		container.Healthy = false
+	}
+}
+
+// forgetRestarts drop history of removed container
+func (e *Engine) forgetRestarts(ID string) {
+	e.restartsMu.Lock()
+	defer e.restartsMu.Unlock()
+	if h, ok := e.restarts[ID]; ok {
+		e.leaveCrashLoop(ID, h)
+		delete(e.restarts, ID)
+	}
+}
+
+// Restarts return restarts of each container within window
+func (e *Engine) Restarts() map[string]types.RestartStatus {
+	e.restartsMu.Lock()
+	defer e.restartsMu.Unlock()
+	now := time.Now()
+	r := map[string]types.RestartStatus{}
+	for ID, h := range e.restarts {
+		h.prune(now, e.config.CrashLoop.Window)
+		if len(h.deaths) == 0 && !h.looping {
+			continue
+		}
+		r[ID] = h.status()
+	}
+	return r
+}
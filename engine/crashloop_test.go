@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/projecteru2/agent/types"
+)
+
+func TestCrashLoop(t *testing.T) {
+	e := mockNewEngine()
+	e.config.CrashLoop = types.CrashLoopConfig{Window: time.Minute, Restarts: 3}
+	container := &types.Container{Name: "app", EntryPoint: "web"}
+	container.ID = "id"
+	container.Healthy = true
+
+	for i := 0; i < 2; i++ {
+		e.recordStart(container)
+		e.recordDie(container, 1)
+	}
+	e.markCrashLoop(container)
+	assert.False(t, container.CrashLoop)
+	assert.Equal(t, 2, container.Restarts)
+	assert.True(t, container.Healthy)
+
+	e.recordStart(container)
+	e.recordDie(container, 137)
+	e.markCrashLoop(container)
+	assert.True(t, container.CrashLoop)
+	assert.Equal(t, 3, container.Restarts)
+	assert.False(t, container.Healthy)
+	assert.Equal(t, map[string]types.RestartStatus{"id": {CrashLoop: true, Restarts: 3, ExitCodes: []int{1, 1, 137}}}, e.Restarts())
+
+	// deaths out of window, still looping until running stably
+	e.restarts["id"].deaths[0].at = time.Now().Add(-2 * time.Minute)
+	e.restarts["id"].deaths[1].at = time.Now().Add(-2 * time.Minute)
+	assert.Equal(t, types.RestartStatus{CrashLoop: true, Restarts: 1, ExitCodes: []int{137}}, e.Restarts()["id"])
+
+	// ran for a whole window before died
+	e.recordStart(container)
+	e.restarts["id"].startedAt = time.Now().Add(-2 * time.Minute)
+	e.recordDie(container, 0)
+	assert.Equal(t, types.RestartStatus{Restarts: 1, ExitCodes: []int{0}}, e.Restarts()["id"])
+
+	e.forgetRestarts("id")
+	assert.Empty(t, e.Restarts())
+}
+
+func TestCrashLoopStopAndRename(t *testing.T) {
+	e := mockNewEngine()
+	e.config.CrashLoop = types.CrashLoopConfig{Window: time.Minute, Restarts: 2}
+	container := &types.Container{Name: "old", EntryPoint: "web"}
+	container.ID = "stop"
+
+	// docker stop isn't a crash
+	e.recordStart(container)
+	e.recordKill(container.ID)
+	e.recordDie(container, 143)
+	assert.Empty(t, e.Restarts())
+
+	for i := 0; i < 2; i++ {
+		e.recordStart(container)
+		e.recordDie(container, 1)
+	}
+	assert.True(t, e.Restarts()["stop"].CrashLoop)
+	assert.Equal(t, 1.0, testutil.ToFloat64(crashLoops.WithLabelValues("old", "web")))
+
+	// gauge of old name is decreased after renamed
+	renamed := *container
+	renamed.Name = "new"
+	e.recordStart(&renamed)
+	e.forgetRestarts(container.ID)
+	assert.Equal(t, 0.0, testutil.ToFloat64(crashLoops.WithLabelValues("old", "web")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(crashLoops.WithLabelValues("new", "web")))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 
 	engineapi "github.com/docker/docker/client"
@@ -32,6 +33,43 @@ type Engine struct {
 	forwards  *utils.HashBackends
 
 	dockerized bool
+
+	// log writers of attached containers
+	writers   map[string]*logWriter
+	writersMu sync.Mutex
+
+	// restart history for crash loop detection
+	restarts   map[string]*restartHistory
+	restartsMu sync.Mutex
+
+	// oom killed containers not started again, event time kept
+	ooms   map[string]int64
+	oomsMu sync.Mutex
+
+	// health check results of each container
+	healthStates map[string]*healthState
+	healthMu     sync.Mutex
+
+	// status waiting to be pushed to store
+	statuses *statusQueue
+	// status last sent of each container
+	reported   map[string]*reportedStatus
+	reportedMu sync.Mutex
+
+	// last reconciliation between docker and core
+	reconciliation types.Reconciliation
+	reconcileMu    sync.Mutex
+
+	// guard fields can be reloaded
+	configMu sync.RWMutex
+	loader   func() (*types.Config, error)
+	retuneC  chan struct{}
+
+	// cancelled when shutting down
+	ctx    context.Context
+	cancel context.CancelFunc
+	// attached containers, waited when shutting down
+	attached sync.WaitGroup
 }
 
 //NewEngine make a engine instance
@@ -75,11 +113,17 @@ func NewEngine(config *types.Config) (*Engine, error) {
 	engine.memory = int64(memory.Total)
 	engine.transfers = utils.NewHashBackends(config.Metrics.Transfers)
 	engine.forwards = utils.NewHashBackends(config.Log.Forwards)
+	engine.retuneC = make(chan struct{}, 1)
+	engine.ctx, engine.cancel = context.WithCancel(context.Background())
+	engine.statuses = newStatusQueue(engine.pushContainersStatus, config.Status)
 	return engine, nil
 }
 
 //Run will start agent
 func (e *Engine) Run() error {
+	// push status in background
+	go e.statuses.run(e.ctx)
+
 	// load container
 	if err := e.load(); err != nil {
 		return err
@@ -91,6 +135,9 @@ func (e *Engine) Run() error {
 	// start health check
 	go e.healthCheck()
 
+	// start reconciliation
+	go e.reconcileLoop()
+
 	// tell core this node is ready
 	if err := e.activated(true); err != nil {
 		return err
@@ -100,13 +147,24 @@ func (e *Engine) Run() error {
 	// wait for signal
 	var c = make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGINT, syscall.SIGHUP, syscall.SIGKILL, syscall.SIGTERM, syscall.SIGQUIT)
-	select {
-	case s := <-c:
-		log.Infof("[Engine] Agent caught system signal %s, exiting", s)
-		return nil
-	case err := <-errChan:
-		e.crash()
-		return err
+	for {
+		select {
+		case s := <-c:
+			if s == syscall.SIGHUP {
+				log.Info("[Engine] Agent caught SIGHUP, reloading config")
+				if err := e.reload(); err != nil {
+					log.Errorf("[Engine] Reload config failed %v", err)
+				}
+				continue
+			}
+			log.Infof("[Engine] Agent caught system signal %s, exiting", s)
+			e.shutdown()
+			return nil
+		case err := <-errChan:
+			e.crash()
+			e.shutdown()
+			return err
+		}
 	}
 }
 
@@ -122,9 +180,7 @@ func (e *Engine) crash() error {
 			return err
 		}
 		container.Healthy = false
-		if err := e.store.SetContainerStatus(context.Background(), container, e.node); err != nil {
-			return err
-		}
+		e.setContainerStatus(container)
 		log.Infof("[crash] mark %s unhealthy", coreutils.ShortID(container.ID))
 	}
 	return e.activated(false)
@@ -279,6 +279,8 @@ func mockNewEngine() *Engine {
 	engine.cpuCore = float64(runtime.NumCPU())
 	engine.transfers = agentutils.NewHashBackends([]string{"127.0.0.1:8125"})
 	engine.forwards = agentutils.NewHashBackends([]string{"udp://127.0.0.1:5144"})
+	engine.ctx, engine.cancel = context.WithCancel(context.Background())
+	engine.statuses = newStatusQueue(engine.pushContainersStatus, engine.config.Status)
 
 	return engine
 }
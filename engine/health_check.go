@@ -13,10 +13,19 @@ import (
 )
 
 func (e *Engine) healthCheck() {
-	tick := time.NewTicker(time.Duration(e.config.HealthCheckInterval) * time.Second)
-	defer tick.Stop()
-	for ; ; <-tick.C {
+	tick := time.NewTicker(e.healthCheckInterval())
+	defer func() { tick.Stop() }()
+	for {
 		go e.checkAllContainers()
+		select {
+		case <-tick.C:
+		case <-e.ctx.Done():
+			return
+		case <-e.retuneC:
+			// interval reloaded
+			tick.Stop()
+			tick = time.NewTicker(e.healthCheckInterval())
+		}
 	}
 }
 
@@ -26,7 +35,7 @@ func (e *Engine) healthCheck() {
 // 为了保证最终数据一致性这里也要检测
 func (e *Engine) checkAllContainers() {
 	log.Debug("[checkAllContainers] health check begin")
-	timeout := time.Duration(e.config.HealthCheckTimeout) * time.Second
+	timeout := e.healthCheckTimeout()
 	containers, err := e.listContainers(true, nil)
 	if err != nil {
 		log.Errorf("[checkAllContainers] Error when list all containers with label \"ERU=1\": %v", err)
@@ -53,18 +62,70 @@ func (e *Engine) checkOneContainer(container *types.Container, timeout time.Dura
 	// 理论上这里都是 running 的容器，因为 listContainers 标记为 all=false 了
 	// 并且都有 healthcheck 标记
 	// 检查现在是不是还健康
-	// for safe
-	container.Healthy = container.Running
+	// 没有 eru 的 health check 时 detectContainer 已经按 running, paused 和 docker 的 health 算好了
 	if container.HealthCheck != nil {
-		container.Healthy = checkSingleContainerHealthy(container, timeout)
+		alive := container.Running && !container.Paused && !container.CrashLoop
+		container.Healthy = alive && e.judgeHealth(container, checkSingleContainerHealthy(container, timeout))
+		if !alive {
+			e.forgetHealth(container.ID)
+		}
 	}
 
-	if err := e.store.SetContainerStatus(context.Background(), container, e.node); err != nil {
-		log.Errorf("[checkOneContainer] update deploy status failed %v", err)
-	}
+	e.setContainerStatus(container)
 	return
 }
 
+// consecutive results of health check since container started
+type healthState struct {
+	startedAt time.Time
+	healthy   bool
+	failures  int
+	successes int
+}
+
+// judgeHealth decide health by consecutive results
+// healthy container turns unhealthy after failure threshold, and back after success threshold
+func (e *Engine) judgeHealth(container *types.Container, ok bool) bool {
+	policy := e.healthCheckPolicy(container.HealthCheckPolicy)
+	e.healthMu.Lock()
+	defer e.healthMu.Unlock()
+	if e.healthStates == nil {
+		e.healthStates = map[string]*healthState{}
+	}
+	state, found := e.healthStates[container.ID]
+	if !found || !state.startedAt.Equal(container.StartedAt) {
+		// restarted, judge again
+		state = &healthState{startedAt: container.StartedAt}
+		e.healthStates[container.ID] = state
+	}
+	if ok {
+		state.failures = 0
+		state.successes++
+		if !state.healthy && state.successes >= policy.SuccessThreshold {
+			state.healthy = true
+		}
+		return state.healthy
+	}
+	state.successes = 0
+	if time.Since(container.StartedAt) < time.Duration(policy.StartPeriod)*time.Second {
+		// still starting
+		return state.healthy
+	}
+	state.failures++
+	if state.healthy && state.failures < policy.FailureThreshold {
+		log.Infof("[judgeHealth] Container %s failed %d/%d", coreutils.ShortID(container.ID), state.failures, policy.FailureThreshold)
+	} else {
+		state.healthy = false
+	}
+	return state.healthy
+}
+
+func (e *Engine) forgetHealth(ID string) {
+	e.healthMu.Lock()
+	defer e.healthMu.Unlock()
+	delete(e.healthStates, ID)
+}
+
 func checkSingleContainerHealthy(container *types.Container, timeout time.Duration) bool {
 	tcpChecker := []string{}
 	httpChecker := []string{}
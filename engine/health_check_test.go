@@ -7,12 +7,10 @@ import (
 	"time"
 
 	"github.com/docker/docker/pkg/stringid"
-	"github.com/projecteru2/agent/store/mocks"
 	"github.com/projecteru2/agent/types"
 	coretypes "github.com/projecteru2/core/types"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/mock"
 )
 
 func TestCheckSingleContainerHealthy(t *testing.T) {
@@ -44,11 +42,11 @@ func TestCheckAllContainers(t *testing.T) {
 	log.SetLevel(log.DebugLevel)
 
 	e := mockNewEngine()
-	mockStore := e.store.(*mocks.Store)
-	mockStore.On("SetContainerStatus", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 	e.checkAllContainers()
 
 	time.Sleep(1 * time.Second)
+	// pushed through queue
+	assert.Len(t, pendingIDs(e), 1)
 }
 
 func TestCheckMethodTCP(t *testing.T) {
@@ -70,3 +68,32 @@ func TestCheckMethodHTTP(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 	assert.True(t, checkHTTP(stringid.GenerateRandomID(), []string{"http://127.0.0.1:10234/"}, 404, 5*time.Second))
 }
+
+func TestJudgeHealth(t *testing.T) {
+	e := mockNewEngine()
+	e.config.HealthCheckFailureThreshold = 3
+	e.config.HealthCheckSuccessThreshold = 2
+	container := &types.Container{StartedAt: time.Now().Add(-time.Minute)}
+	container.ID = "id"
+
+	assert.False(t, e.judgeHealth(container, true))
+	assert.True(t, e.judgeHealth(container, true))
+	// single failure is tolerated
+	assert.True(t, e.judgeHealth(container, false))
+	assert.True(t, e.judgeHealth(container, true))
+	assert.True(t, e.judgeHealth(container, false))
+	assert.True(t, e.judgeHealth(container, false))
+	assert.False(t, e.judgeHealth(container, false))
+	assert.False(t, e.judgeHealth(container, true))
+	assert.True(t, e.judgeHealth(container, true))
+
+	// restarted, override from label meta, failures in start period ignored
+	container.StartedAt = time.Now()
+	container.HealthCheckPolicy = types.HealthCheckPolicy{SuccessThreshold: 1, StartPeriod: 60}
+	assert.False(t, e.judgeHealth(container, false))
+	assert.Equal(t, 0, e.healthStates["id"].failures)
+	assert.True(t, e.judgeHealth(container, true))
+
+	e.forgetHealth("id")
+	assert.Empty(t, e.healthStates)
+}
@@ -76,6 +76,8 @@ func (e *Engine) detectContainer(ID string) (*types.Container, error) {
 		}
 		container.Networks = networks
 	}
+	e.markCrashLoop(container)
+	e.markOOM(container)
 
 	return container, nil
 }
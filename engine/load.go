@@ -1,7 +1,6 @@
 package engine
 
 import (
-	"context"
 	coreutils "github.com/projecteru2/core/utils"
 	log "github.com/sirupsen/logrus"
 )
@@ -28,9 +27,7 @@ func (e *Engine) load() error {
 			//}
 		}
 
-		if err := e.store.SetContainerStatus(context.Background(), c, e.node); err != nil {
-			log.Errorf("[load] update deploy status failed %v", err)
-		}
+		e.setContainerStatus(c)
 	}
 	return nil
 }
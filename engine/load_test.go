@@ -20,9 +20,10 @@ func TestLoad(t *testing.T) {
 	n := new(coretypes.Node)
 	mockStore.On("GetNode", mock.AnythingOfType("string")).Return(n, nil)
 	mockStore.On("UpdateNode", mock.Anything).Return(nil)
-	mockStore.On("SetContainerStatus", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
 	err := e.load()
 	assert.NoError(t, err)
 	time.Sleep(1 * time.Second)
+	// pushed through queue
+	assert.Len(t, pendingIDs(e), 1)
 }
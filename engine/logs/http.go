@@ -0,0 +1,347 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/projecteru2/agent/types"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// HTTPNDJSON post logs as newline delimited json
+	HTTPNDJSON = "ndjson"
+	// HTTPElasticsearch post logs in elasticsearch _bulk format
+	HTTPElasticsearch = "es"
+
+	defaultHTTPIndex     = "eru-{app}-{date}"
+	defaultHTTPBatch     = 500
+	defaultHTTPInterval  = time.Second
+	defaultHTTPRetries   = 5
+	maxHTTPRetryInterval = 30 * time.Second
+	// batches buffered while sender is busy
+	maxHTTPPendingBatches = 10
+)
+
+// how long close waits for sending, retries are cancelled then
+var httpCloseTimeout = 5 * time.Second
+
+var (
+	errHTTPBusy   = errors.New("[http] Too many logs pending")
+	errHTTPClosed = errors.New("[http] Encoder closed")
+)
+
+// HTTPEncoder post logs to http endpoint in batches
+// batches are sent by another goroutine with retry
+// once a batch finally failed, encoder returns error so writer can reconnect
+// and lines of failed batch are handed back by spill
+type HTTPEncoder struct {
+	sync.Mutex
+	client    *http.Client
+	transport *http.Transport
+	endpoint  string
+	format    string
+	index     string
+	batch     int
+	retries   int
+
+	buf     *bytes.Buffer
+	lines   []*types.Log
+	err     error
+	closed  bool
+	spill   func([]*types.Log)
+	batches chan *httpBatch
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+type httpBatch struct {
+	data  []byte
+	lines []*types.Log
+}
+
+// NewHTTPEncoder return a http encoder
+// format: ndjson or es
+// index: es index template, {app} and {date} will be replaced
+// batch, flush_interval and retries control sending
+func NewHTTPEncoder(endpoint string, query url.Values, tlsConfig *tls.Config) (*HTTPEncoder, error) {
+	transport := &http.Transport{TLSClientConfig: tlsConfig}
+	e := &HTTPEncoder{
+		client:    &http.Client{Timeout: 30 * time.Second, Transport: transport},
+		transport: transport,
+		endpoint:  endpoint,
+		format:    HTTPNDJSON,
+		index:     defaultHTTPIndex,
+		batch:     defaultHTTPBatch,
+		retries:   defaultHTTPRetries,
+		buf:       &bytes.Buffer{},
+		batches:   make(chan *httpBatch, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	if v := query.Get("format"); v != "" {
+		if v != HTTPNDJSON && v != HTTPElasticsearch {
+			return nil, fmt.Errorf("[http] Invalid format: %s", v)
+		}
+		e.format = v
+	}
+	if v := query.Get("index"); v != "" {
+		e.index = v
+	}
+	if v := query.Get("batch"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("[http] Invalid batch: %s", v)
+		}
+		e.batch = n
+	}
+	if v := query.Get("retries"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("[http] Invalid retries: %s", v)
+		}
+		e.retries = n
+	}
+	interval := defaultHTTPInterval
+	if v := query.Get("flush_interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("[http] Invalid flush interval: %s", v)
+		}
+		interval = d
+	}
+	go e.send()
+	go e.tick(interval)
+	return e, nil
+}
+
+// Encode .
+func (e *HTTPEncoder) Encode(logline *types.Log) error {
+	data, err := json.Marshal(logline)
+	if err != nil {
+		return err
+	}
+	e.Lock()
+	defer e.Unlock()
+	if e.closed {
+		return errHTTPClosed
+	}
+	if e.err != nil {
+		return e.err
+	}
+	if len(e.lines) >= maxHTTPPendingBatches*e.batch && !e.flush() {
+		// sender stuck in retrying, don't let logs pile up in memory
+		return errHTTPBusy
+	}
+	if e.format == HTTPElasticsearch {
+		action, _ := json.Marshal(map[string]map[string]string{"index": {"_index": e.indexName(logline)}})
+		e.buf.Write(action)
+		e.buf.WriteByte('\n')
+	}
+	e.buf.Write(data)
+	e.buf.WriteByte('\n')
+	e.lines = append(e.lines, logline)
+	if len(e.lines) >= e.batch {
+		e.flush()
+	}
+	return nil
+}
+
+// SetSpill set where lines of failed batches go
+func (e *HTTPEncoder) SetSpill(spill func([]*types.Log)) {
+	e.Lock()
+	defer e.Unlock()
+	e.spill = spill
+}
+
+// Close send logs buffered within timeout, lines not sent are spilled
+func (e *HTTPEncoder) Close() error {
+	timer := time.AfterFunc(httpCloseTimeout, e.cancel)
+	defer timer.Stop()
+	defer e.cancel()
+	close(e.stop)
+	e.Lock()
+	e.closed = true
+	batch := e.take()
+	spill := e.spill
+	e.Unlock()
+	// sender takes lock when failed, so wait for it without lock
+	if batch != nil {
+		select {
+		case e.batches <- batch:
+		case <-e.ctx.Done():
+			e.drop(spill, batch)
+		}
+	}
+	close(e.batches)
+	<-e.done
+	e.transport.CloseIdleConnections()
+	return nil
+}
+
+// take lines buffered as a batch, must be called with lock held
+func (e *HTTPEncoder) take() *httpBatch {
+	if len(e.lines) == 0 {
+		return nil
+	}
+	batch := &httpBatch{data: e.buf.Bytes(), lines: e.lines}
+	e.buf = &bytes.Buffer{}
+	e.lines = nil
+	return batch
+}
+
+// flush hand batch over to sender without blocking, must be called with lock held
+// return false if sender is busy
+func (e *HTTPEncoder) flush() bool {
+	if len(e.lines) == 0 || e.closed {
+		return true
+	}
+	select {
+	case e.batches <- &httpBatch{data: e.buf.Bytes(), lines: e.lines}:
+	default:
+		return false
+	}
+	e.buf = &bytes.Buffer{}
+	e.lines = nil
+	return true
+}
+
+func (e *HTTPEncoder) drop(spill func([]*types.Log), batch *httpBatch) {
+	if spill != nil {
+		spill(batch.lines)
+	} else {
+		log.Warnf("[http] %d lines dropped", len(batch.lines))
+	}
+}
+
+func (e *HTTPEncoder) tick(interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			e.Lock()
+			e.flush()
+			e.Unlock()
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+func (e *HTTPEncoder) send() {
+	defer close(e.done)
+	for batch := range e.batches {
+		if err := e.post(batch.data); err != nil {
+			log.Errorf("[http] Send logs to %s failed %s", e.endpoint, err)
+			e.Lock()
+			e.err = err
+			spill := e.spill
+			e.Unlock()
+			e.drop(spill, batch)
+		}
+	}
+}
+
+func (e *HTTPEncoder) post(data []byte) error {
+	var err error
+	wait := 500 * time.Millisecond
+	for i := 0; i <= e.retries; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(wait):
+			case <-e.ctx.Done():
+				return e.ctx.Err()
+			}
+			if wait *= 2; wait > maxHTTPRetryInterval {
+				wait = maxHTTPRetryInterval
+			}
+		}
+		var retry time.Duration
+		if retry, err = e.postOnce(data); err == nil {
+			return nil
+		}
+		if retry < 0 {
+			// won't be better by retry
+			return err
+		}
+		if retry > wait {
+			wait = retry
+		}
+		log.Warnf("[http] Post to %s failed %s, retrying", e.endpoint, err)
+	}
+	return err
+}
+
+// postOnce return how long to wait before retry, negative means no retry
+func (e *HTTPEncoder) postOnce(data []byte) (time.Duration, error) {
+	path := e.endpoint
+	if e.format == HTTPElasticsearch && !strings.HasSuffix(path, "/_bulk") {
+		path = strings.TrimSuffix(path, "/") + "/_bulk"
+	}
+	req, err := http.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := e.client.Do(req.WithContext(e.ctx))
+	if err != nil {
+		if e.ctx.Err() != nil {
+			return -1, err
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		retry := time.Duration(0)
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			retry = time.Duration(seconds) * time.Second
+		}
+		return retry, fmt.Errorf("status %d", resp.StatusCode)
+	case resp.StatusCode >= 500:
+		return 0, fmt.Errorf("status %d", resp.StatusCode)
+	case resp.StatusCode >= 300:
+		return -1, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if e.format == HTTPElasticsearch {
+		e.checkBulk(resp.Body)
+	}
+	return 0, nil
+}
+
+// bulk api returns 200 even if some items failed
+func (e *HTTPEncoder) checkBulk(body io.Reader) {
+	result := struct {
+		Errors bool `json:"errors"`
+	}{}
+	data, err := ioutil.ReadAll(body)
+	if err != nil || json.Unmarshal(data, &result) != nil {
+		return
+	}
+	if result.Errors {
+		log.Warnf("[http] Some logs rejected by %s", e.endpoint)
+	}
+}
+
+func (e *HTTPEncoder) indexName(logline *types.Log) string {
+	return strings.NewReplacer(
+		"{app}", logline.Name,
+		"{date}", logTime(logline).Format("2006.01.02"),
+	).Replace(e.index)
+}
@@ -0,0 +1,150 @@
+package logs
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/projecteru2/agent/types"
+)
+
+type bulkServer struct {
+	sync.Mutex
+	paths    []string
+	lines    []string
+	failures int
+}
+
+func (s *bulkServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	s.paths = append(s.paths, req.URL.Path)
+	scanner := bufio.NewScanner(req.Body)
+	for scanner.Scan() {
+		s.lines = append(s.lines, scanner.Text())
+	}
+	w.Write([]byte(`{"errors":false}`))
+}
+
+func TestHTTPEncoderNDJSON(t *testing.T) {
+	bulk := &bulkServer{failures: 1}
+	server := httptest.NewServer(bulk)
+	defer server.Close()
+
+	enc, err := NewHTTPEncoder(server.URL+"/logs", url.Values{"batch": {"2"}}, nil)
+	assert.NoError(t, err)
+	for _, data := range []string{"a", "b", "c"} {
+		assert.NoError(t, enc.Encode(&types.Log{Name: "app", Data: data}))
+	}
+	assert.NoError(t, enc.Close())
+
+	// retried after 503
+	assert.Equal(t, []string{"/logs", "/logs"}, bulk.paths)
+	assert.Len(t, bulk.lines, 3)
+	assert.Contains(t, bulk.lines[2], `"data":"c"`)
+}
+
+func TestHTTPEncoderElasticsearch(t *testing.T) {
+	bulk := &bulkServer{}
+	server := httptest.NewServer(bulk)
+	defer server.Close()
+
+	f := &forward{addr: strings.TrimPrefix(server.URL, "http://"), scheme: "http", query: url.Values{"format": {"es"}, "index": {"logs-{app}-{date}"}}}
+	enc, err := f.createHTTPEncoder()
+	assert.NoError(t, err)
+	assert.NoError(t, enc.Encode(&types.Log{Name: "app", Data: "a", Datetime: "2020-03-01 10:00:00"}))
+	assert.NoError(t, enc.Close())
+
+	assert.Equal(t, []string{"/_bulk"}, bulk.paths)
+	assert.Equal(t, `{"index":{"_index":"logs-app-2020.03.01"}}`, bulk.lines[0])
+	assert.Contains(t, bulk.lines[1], `"data":"a"`)
+}
+
+func TestHTTPEncoderFailed(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	enc, err := NewHTTPEncoder(server.URL, url.Values{"batch": {"1"}}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, enc.Encode(&types.Log{}))
+	assert.NoError(t, enc.Close())
+	assert.Error(t, enc.Encode(&types.Log{}))
+
+	_, err = NewHTTPEncoder(server.URL, url.Values{"format": {"xml"}}, nil)
+	assert.Error(t, err)
+}
+
+func TestHTTPEncoderCloseSpill(t *testing.T) {
+	httpCloseTimeout = 100 * time.Millisecond
+	defer func() { httpCloseTimeout = 5 * time.Second }()
+	bulk := &bulkServer{failures: 100}
+	server := httptest.NewServer(bulk)
+	defer server.Close()
+
+	enc, err := NewHTTPEncoder(server.URL, url.Values{"batch": {"2"}}, nil)
+	assert.NoError(t, err)
+	spilled := []string{}
+	enc.SetSpill(func(lines []*types.Log) {
+		for _, l := range lines {
+			spilled = append(spilled, l.Data)
+		}
+	})
+	for _, data := range []string{"a", "b", "c"} {
+		assert.NoError(t, enc.Encode(&types.Log{Name: "app", Data: data}))
+	}
+	start := time.Now()
+	assert.NoError(t, enc.Close())
+	// retries cancelled, nothing lost
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, []string{"a", "b", "c"}, spilled)
+}
+
+func TestHTTPEncoderCloseWhileFailing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	enc, err := NewHTTPEncoder(server.URL, url.Values{"batch": {"1"}, "flush_interval": {"1h"}}, nil)
+	assert.NoError(t, err)
+	var mu sync.Mutex
+	spilled := 0
+	enc.SetSpill(func(lines []*types.Log) {
+		mu.Lock()
+		defer mu.Unlock()
+		spilled += len(lines)
+	})
+	// one in flight, one queued, the last one waits for close
+	assert.NoError(t, enc.Encode(&types.Log{Name: "app", Data: "a"}))
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, enc.Encode(&types.Log{Name: "app", Data: "b"}))
+	assert.NoError(t, enc.Encode(&types.Log{Name: "app", Data: "c"}))
+
+	closed := make(chan struct{})
+	go func() {
+		enc.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("close blocked")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 3, spilled)
+	assert.Error(t, enc.Encode(&types.Log{Name: "app", Data: "d"}))
+}
@@ -0,0 +1,195 @@
+package logs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/projecteru2/agent/types"
+	log "github.com/sirupsen/logrus"
+)
+
+var kafkaAcks = map[string]sarama.RequiredAcks{
+	"0":     sarama.NoResponse,
+	"none":  sarama.NoResponse,
+	"1":     sarama.WaitForLocal,
+	"local": sarama.WaitForLocal,
+	"-1":    sarama.WaitForAll,
+	"all":   sarama.WaitForAll,
+}
+
+var kafkaCompressions = map[string]sarama.CompressionCodec{
+	"none":   sarama.CompressionNone,
+	"gzip":   sarama.CompressionGZIP,
+	"snappy": sarama.CompressionSnappy,
+	"lz4":    sarama.CompressionLZ4,
+	"zstd":   sarama.CompressionZSTD,
+}
+
+var errKafkaClosed = errors.New("[kafka] Encoder closed")
+
+// KafkaEncoder send logs to kafka in batches
+// messages are keyed by container id, so lines of a container stay in order
+// lines failed to deliver are handed back by spill
+type KafkaEncoder struct {
+	sync.Mutex
+	producer sarama.AsyncProducer
+	topic    string
+	err      error
+	spill    func([]*types.Log)
+	done     chan struct{}
+	// input must not be sent after closed, errors are collected meanwhile
+	inputMu sync.Mutex
+	closed  bool
+}
+
+// NewKafkaConfig make producer config from query of forward addr
+// acks: none, local or all
+// compression: none, gzip, snappy, lz4 or zstd
+// flush_messages, flush_bytes and flush_frequency control batching
+func NewKafkaConfig(query url.Values) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.ClientID = "eru-agent"
+	config.Producer.Return.Errors = true
+	config.Producer.Return.Successes = false
+	config.Producer.RequiredAcks = sarama.WaitForLocal
+	config.Producer.Flush.Messages = 100
+	config.Producer.Flush.Frequency = 500 * time.Millisecond
+
+	if v := query.Get("acks"); v != "" {
+		acks, ok := kafkaAcks[v]
+		if !ok {
+			return nil, fmt.Errorf("[kafka] Invalid acks: %s", v)
+		}
+		config.Producer.RequiredAcks = acks
+	}
+	if v := query.Get("compression"); v != "" {
+		codec, ok := kafkaCompressions[v]
+		if !ok {
+			return nil, fmt.Errorf("[kafka] Invalid compression: %s", v)
+		}
+		config.Producer.Compression = codec
+		if codec == sarama.CompressionZSTD {
+			config.Version = sarama.V2_1_0_0
+		}
+	}
+	if v := query.Get("flush_messages"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		config.Producer.Flush.Messages = n
+	}
+	if v := query.Get("flush_bytes"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		config.Producer.Flush.Bytes = n
+	}
+	if v := query.Get("flush_frequency"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, err
+		}
+		config.Producer.Flush.Frequency = d
+	}
+	return config, config.Validate()
+}
+
+// NewKafkaEncoder return a kafka encoder
+func NewKafkaEncoder(brokers []string, topic string, config *sarama.Config) (*KafkaEncoder, error) {
+	if topic == "" {
+		return nil, fmt.Errorf("[kafka] Topic not set")
+	}
+	producer, err := sarama.NewAsyncProducer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	enc := &KafkaEncoder{producer: producer, topic: topic, done: make(chan struct{})}
+	go enc.collect()
+	return enc, nil
+}
+
+// collect errors of async producer
+func (e *KafkaEncoder) collect() {
+	defer close(e.done)
+	for err := range e.producer.Errors() {
+		log.Errorf("[kafka] Send log to %s failed %s", e.topic, err.Err)
+		e.Lock()
+		e.err = err.Err
+		spill := e.spill
+		e.Unlock()
+		if spill == nil {
+			log.Warn("[kafka] 1 line dropped")
+			continue
+		}
+		if logline := decodeMessage(err.Msg); logline != nil {
+			spill([]*types.Log{logline})
+		}
+	}
+}
+
+func decodeMessage(msg *sarama.ProducerMessage) *types.Log {
+	if msg == nil || msg.Value == nil {
+		return nil
+	}
+	data, err := msg.Value.Encode()
+	if err != nil {
+		return nil
+	}
+	logline := &types.Log{}
+	if err := json.Unmarshal(data, logline); err != nil {
+		log.Errorf("[kafka] Decode failed message failed %s", err)
+		return nil
+	}
+	return logline
+}
+
+// SetSpill set where lines failed to deliver go
+func (e *KafkaEncoder) SetSpill(spill func([]*types.Log)) {
+	e.Lock()
+	defer e.Unlock()
+	e.spill = spill
+}
+
+// Encode .
+func (e *KafkaEncoder) Encode(logline *types.Log) error {
+	data, err := json.Marshal(logline)
+	if err != nil {
+		return err
+	}
+	e.Lock()
+	err = e.err
+	e.Unlock()
+	if err != nil {
+		// let writer reconnect
+		return err
+	}
+	e.inputMu.Lock()
+	defer e.inputMu.Unlock()
+	if e.closed {
+		return errKafkaClosed
+	}
+	e.producer.Input() <- &sarama.ProducerMessage{
+		Topic: e.topic,
+		Key:   sarama.StringEncoder(logline.ID),
+		Value: sarama.ByteEncoder(data),
+	}
+	return nil
+}
+
+// Close flush buffered messages, lines failed are spilled
+func (e *KafkaEncoder) Close() error {
+	e.inputMu.Lock()
+	e.closed = true
+	e.inputMu.Unlock()
+	e.producer.AsyncClose()
+	<-e.done
+	return nil
+}
@@ -0,0 +1,92 @@
+package logs
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/projecteru2/agent/types"
+)
+
+func TestKafkaConfig(t *testing.T) {
+	config, err := NewKafkaConfig(url.Values{"acks": {"all"}, "compression": {"snappy"}, "flush_messages": {"10"}})
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.WaitForAll, config.Producer.RequiredAcks)
+	assert.Equal(t, sarama.CompressionSnappy, config.Producer.Compression)
+	assert.Equal(t, 10, config.Producer.Flush.Messages)
+
+	_, err = NewKafkaConfig(url.Values{"acks": {"some"}})
+	assert.Error(t, err)
+	_, err = NewKafkaConfig(url.Values{"compression": {"rar"}})
+	assert.Error(t, err)
+}
+
+func TestKafkaEncoder(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("logs", 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t),
+	})
+
+	f := &forward{addr: broker.Addr(), scheme: "kafka", path: "/logs", query: url.Values{"flush_messages": {"2"}}}
+	enc, err := f.createKafkaEncoder()
+	assert.NoError(t, err)
+	assert.NoError(t, enc.Encode(&types.Log{ID: "id", Data: "a"}))
+	assert.NoError(t, enc.Encode(&types.Log{ID: "id", Data: "b"}))
+
+	produced := func() bool {
+		for _, r := range broker.History() {
+			if _, ok := r.Request.(*sarama.ProduceRequest); ok {
+				return true
+			}
+		}
+		return false
+	}
+	for i := 0; i < 30 && !produced(); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.True(t, produced())
+	assert.NoError(t, enc.Close())
+
+	f.path = ""
+	_, err = f.createKafkaEncoder()
+	assert.Error(t, err)
+}
+
+func TestKafkaEncoderSpill(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("logs", 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).
+			SetError("logs", 0, sarama.ErrMessageSizeTooLarge),
+	})
+
+	config, err := NewKafkaConfig(url.Values{})
+	assert.NoError(t, err)
+	enc, err := NewKafkaEncoder([]string{broker.Addr()}, "logs", config)
+	assert.NoError(t, err)
+	spilled := make(chan *types.Log, 1)
+	enc.SetSpill(func(lines []*types.Log) {
+		for _, l := range lines {
+			spilled <- l
+		}
+	})
+	assert.NoError(t, enc.Encode(&types.Log{ID: "id", Data: "a"}))
+	select {
+	case l := <-spilled:
+		assert.Equal(t, "a", l.Data)
+	case <-time.After(3 * time.Second):
+		t.Fatal("not spilled")
+	}
+	assert.NoError(t, enc.Close())
+	assert.Error(t, enc.Encode(&types.Log{ID: "id", Data: "b"}))
+}
@@ -0,0 +1,84 @@
+package logs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/projecteru2/agent/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var rateLimitedLines = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "log_rate_limited_lines",
+	Help: "log lines dropped by rate limit.",
+}, []string{"appname"})
+
+func init() {
+	prometheus.MustRegister(rateLimitedLines)
+}
+
+// Limiter is a token bucket limiting log lines of a container
+type Limiter struct {
+	sync.Mutex
+	app    string
+	rate   float64
+	burst  float64
+	sample int
+
+	tokens  float64
+	last    time.Time
+	over    int64
+	dropped int64
+}
+
+// NewLimiter return a limiter, nil if rate is not limited
+func NewLimiter(config types.RateLimitConfig, app string) *Limiter {
+	if config.Rate <= 0 {
+		return nil
+	}
+	burst := float64(config.Burst)
+	if burst < config.Rate {
+		burst = config.Rate
+	}
+	return &Limiter{
+		app:    app,
+		rate:   config.Rate,
+		burst:  burst,
+		sample: config.Sample,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// Allow return whether a line can pass
+// lines over rate are dropped, or 1 of every sample lines pass
+func (l *Limiter) Allow() bool {
+	l.Lock()
+	defer l.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true
+	}
+	l.over++
+	if l.sample > 0 && l.over%int64(l.sample) == 0 {
+		return true
+	}
+	l.dropped++
+	rateLimitedLines.WithLabelValues(l.app).Inc()
+	return false
+}
+
+// Dropped return lines dropped since last call
+func (l *Limiter) Dropped() int64 {
+	l.Lock()
+	defer l.Unlock()
+	dropped := l.dropped
+	l.dropped = 0
+	return dropped
+}
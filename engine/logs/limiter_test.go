@@ -0,0 +1,41 @@
+package logs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/projecteru2/agent/types"
+)
+
+func TestLimiter(t *testing.T) {
+	assert.Nil(t, NewLimiter(types.RateLimitConfig{}, "app"))
+
+	l := NewLimiter(types.RateLimitConfig{Rate: 10, Burst: 20}, "app")
+	passed := 0
+	for i := 0; i < 30; i++ {
+		if l.Allow() {
+			passed++
+		}
+	}
+	assert.Equal(t, 20, passed)
+	assert.Equal(t, int64(10), l.Dropped())
+	assert.Equal(t, int64(0), l.Dropped())
+
+	time.Sleep(200 * time.Millisecond)
+	assert.True(t, l.Allow())
+}
+
+func TestLimiterSample(t *testing.T) {
+	l := NewLimiter(types.RateLimitConfig{Rate: 1, Sample: 5}, "app")
+	passed := 0
+	for i := 0; i < 11; i++ {
+		if l.Allow() {
+			passed++
+		}
+	}
+	// 1 by token, 2 of 10 over rate
+	assert.Equal(t, 3, passed)
+	assert.Equal(t, int64(8), l.Dropped())
+}
@@ -0,0 +1,124 @@
+package logs
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/projecteru2/agent/types"
+)
+
+const (
+	defaultMultilineFlushTimeout = time.Second
+	defaultMultilineMaxLines     = 500
+)
+
+// Multiline assemble lines into one logical event
+// a line matching start begins a new event
+// a line matching continue is appended to the current one
+// with only start, lines not matching start are appended
+// with only continue, lines not matching continue begin a new event
+type Multiline struct {
+	sync.Mutex
+	start    *regexp.Regexp
+	cont     *regexp.Regexp
+	timeout  time.Duration
+	maxLines int
+	emit     func(string)
+
+	lines []string
+	timer *time.Timer
+	gen   uint64
+}
+
+// NewMultiline return a multiline assembler, emit will be called with every event
+func NewMultiline(config types.MultilineConfig, emit func(string)) (*Multiline, error) {
+	if config.Start == "" && config.Continue == "" {
+		return nil, fmt.Errorf("[multiline] Neither start nor continue pattern set")
+	}
+	m := &Multiline{
+		timeout:  config.FlushTimeout,
+		maxLines: config.MaxLines,
+		emit:     emit,
+	}
+	var err error
+	if config.Start != "" {
+		if m.start, err = regexp.Compile(config.Start); err != nil {
+			return nil, err
+		}
+	}
+	if config.Continue != "" {
+		if m.cont, err = regexp.Compile(config.Continue); err != nil {
+			return nil, err
+		}
+	}
+	if m.timeout <= 0 {
+		m.timeout = defaultMultilineFlushTimeout
+	}
+	if m.maxLines <= 0 {
+		m.maxLines = defaultMultilineMaxLines
+	}
+	return m, nil
+}
+
+// Feed a line to assembler
+func (m *Multiline) Feed(line string) {
+	m.Lock()
+	defer m.Unlock()
+	if len(m.lines) > 0 && !m.continued(line) {
+		m.flush()
+	}
+	m.lines = append(m.lines, line)
+	if len(m.lines) >= m.maxLines {
+		m.flush()
+		return
+	}
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+	m.gen++
+	gen := m.gen
+	m.timer = time.AfterFunc(m.timeout, func() { m.expire(gen) })
+}
+
+// Flush emit event buffered
+func (m *Multiline) Flush() {
+	m.Lock()
+	defer m.Unlock()
+	m.flush()
+}
+
+func (m *Multiline) continued(line string) bool {
+	if m.start != nil && m.start.MatchString(line) {
+		return false
+	}
+	if m.cont != nil {
+		return m.cont.MatchString(line)
+	}
+	return true
+}
+
+func (m *Multiline) expire(gen uint64) {
+	m.Lock()
+	defer m.Unlock()
+	// more lines came after timer fired
+	if gen != m.gen {
+		return
+	}
+	m.flush()
+}
+
+func (m *Multiline) flush() {
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	if len(m.lines) == 0 {
+		return
+	}
+	data := strings.Join(m.lines, "\n")
+	m.lines = nil
+	m.emit(data)
+}
@@ -0,0 +1,76 @@
+package logs
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/projecteru2/agent/types"
+)
+
+type events struct {
+	sync.Mutex
+	data []string
+}
+
+func (e *events) emit(data string) {
+	e.Lock()
+	defer e.Unlock()
+	e.data = append(e.data, data)
+}
+
+func (e *events) get() []string {
+	e.Lock()
+	defer e.Unlock()
+	return e.data
+}
+
+func TestMultilineStart(t *testing.T) {
+	evs := &events{}
+	m, err := NewMultiline(types.MultilineConfig{Start: `^\d{4}-`, FlushTimeout: time.Hour}, evs.emit)
+	assert.NoError(t, err)
+
+	m.Feed("2020-03-01 error")
+	m.Feed("Traceback:")
+	m.Feed("  at foo")
+	m.Feed("2020-03-01 ok")
+	assert.Equal(t, []string{"2020-03-01 error\nTraceback:\n  at foo"}, evs.get())
+	m.Flush()
+	assert.Equal(t, "2020-03-01 ok", evs.get()[1])
+}
+
+func TestMultilineContinue(t *testing.T) {
+	evs := &events{}
+	m, err := NewMultiline(types.MultilineConfig{Continue: `^\s`, MaxLines: 3, FlushTimeout: time.Hour}, evs.emit)
+	assert.NoError(t, err)
+
+	m.Feed("Exception")
+	m.Feed("  at a")
+	m.Feed("next")
+	m.Feed(" 1")
+	m.Feed(" 2")
+	m.Feed(" 3")
+	m.Flush()
+	assert.Equal(t, []string{"Exception\n  at a", "next\n 1\n 2", " 3"}, evs.get())
+}
+
+func TestMultilineTimeout(t *testing.T) {
+	evs := &events{}
+	m, err := NewMultiline(types.MultilineConfig{Start: `^\S`, FlushTimeout: 50 * time.Millisecond}, evs.emit)
+	assert.NoError(t, err)
+
+	m.Feed("Exception")
+	m.Feed("  at a")
+	assert.Len(t, evs.get(), 0)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, []string{"Exception\n  at a"}, evs.get())
+}
+
+func TestMultilineInvalid(t *testing.T) {
+	_, err := NewMultiline(types.MultilineConfig{}, nil)
+	assert.Error(t, err)
+	_, err = NewMultiline(types.MultilineConfig{Start: "("}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,241 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// ParseJSON for json lines
+	ParseJSON = "json"
+	// ParseLogfmt for logfmt lines
+	ParseLogfmt = "logfmt"
+	// ParseAuto detect json or logfmt
+	ParseAuto = "auto"
+)
+
+// DefaultParseKeys keys lifted into extra if not specified
+var DefaultParseKeys = []string{"level", "trace_id", "span_id", "request_id"}
+
+// Parser lift selected keys of structured log lines into extra
+type Parser struct {
+	format string
+	keys   map[string]bool
+	strip  bool
+}
+
+type pair struct {
+	key   string
+	value string
+}
+
+// NewParser return a parser
+// strip means lifted keys will be removed from data
+func NewParser(format string, keys []string, strip bool) (*Parser, error) {
+	switch format {
+	case ParseJSON, ParseLogfmt, ParseAuto:
+	default:
+		return nil, fmt.Errorf("[parser] Invalid format: %s", format)
+	}
+	if len(keys) == 0 {
+		keys = DefaultParseKeys
+	}
+	p := &Parser{format: format, keys: map[string]bool{}, strip: strip}
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != "" {
+			p.keys[key] = true
+		}
+	}
+	return p, nil
+}
+
+// Parse return extra and data, data will be the same if line isn't structured
+func (p *Parser) Parse(data string) (map[string]string, string) {
+	trimmed := strings.TrimSpace(data)
+	switch {
+	case p.format == ParseJSON, p.format == ParseAuto && strings.HasPrefix(trimmed, "{"):
+		return p.parseJSON(data, trimmed)
+	default:
+		return p.parseLogfmt(data, trimmed)
+	}
+}
+
+type field struct {
+	key   string
+	value json.RawMessage
+}
+
+func (p *Parser) parseJSON(data, trimmed string) (map[string]string, string) {
+	fields, ok := splitJSON(trimmed)
+	if !ok {
+		return nil, data
+	}
+	extra := map[string]string{}
+	rest := []field{}
+	for _, f := range fields {
+		if !p.keys[f.key] {
+			rest = append(rest, f)
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(f.value, &s); err == nil {
+			extra[f.key] = s
+		} else {
+			extra[f.key] = string(f.value)
+		}
+	}
+	if len(extra) == 0 {
+		return nil, data
+	}
+	if !p.strip {
+		return extra, data
+	}
+	// keep order and escaping of the original line
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	buf.WriteByte('{')
+	for i, f := range rest {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := encoder.Encode(f.key); err != nil {
+			return extra, data
+		}
+		// encoder ends with newline
+		buf.Truncate(buf.Len() - 1)
+		buf.WriteByte(':')
+		buf.Write(f.value)
+	}
+	buf.WriteByte('}')
+	return extra, buf.String()
+}
+
+// splitJSON split a json object into fields in order, false if line isn't one
+func splitJSON(line string) ([]field, bool) {
+	decoder := json.NewDecoder(strings.NewReader(line))
+	if t, err := decoder.Token(); err != nil || t != json.Delim('{') {
+		return nil, false
+	}
+	fields := []field{}
+	for decoder.More() {
+		t, err := decoder.Token()
+		if err != nil {
+			return nil, false
+		}
+		key, ok := t.(string)
+		if !ok {
+			return nil, false
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, false
+		}
+		compacted := &bytes.Buffer{}
+		if err := json.Compact(compacted, value); err != nil {
+			return nil, false
+		}
+		fields = append(fields, field{key, compacted.Bytes()})
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, false
+	}
+	return fields, true
+}
+
+func (p *Parser) parseLogfmt(data, trimmed string) (map[string]string, string) {
+	pairs, ok := splitLogfmt(trimmed)
+	if !ok {
+		return nil, data
+	}
+	extra := map[string]string{}
+	rest := []pair{}
+	for _, kv := range pairs {
+		if p.keys[kv.key] {
+			extra[kv.key] = kv.value
+			continue
+		}
+		rest = append(rest, kv)
+	}
+	if len(extra) == 0 {
+		return nil, data
+	}
+	if !p.strip {
+		return extra, data
+	}
+	buf := &bytes.Buffer{}
+	for i, kv := range rest {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(kv.key)
+		buf.WriteByte('=')
+		if strings.IndexFunc(kv.value, func(r rune) bool { return unicode.IsSpace(r) || r == '"' || r == '=' }) >= 0 || kv.value == "" {
+			buf.WriteString(strconv.Quote(kv.value))
+		} else {
+			buf.WriteString(kv.value)
+		}
+	}
+	return extra, buf.String()
+}
+
+// splitLogfmt split line into pairs, false if line isn't logfmt
+func splitLogfmt(line string) ([]pair, bool) {
+	pairs := []pair{}
+	for len(line) > 0 {
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		if line == "" {
+			break
+		}
+		end := strings.IndexFunc(line, func(r rune) bool { return r == '=' || unicode.IsSpace(r) || r == '"' })
+		if end == 0 {
+			return nil, false
+		}
+		if end < 0 || line[end] != '=' {
+			// bare key is allowed by logfmt, but plain text looks the same
+			return nil, false
+		}
+		key := line[:end]
+		line = line[end+1:]
+		value := ""
+		switch {
+		case strings.HasPrefix(line, `"`):
+			closing := closingQuote(line)
+			if closing < 0 {
+				return nil, false
+			}
+			v, err := strconv.Unquote(line[:closing+1])
+			if err != nil {
+				return nil, false
+			}
+			value = v
+			line = line[closing+1:]
+		default:
+			end = strings.IndexFunc(line, unicode.IsSpace)
+			if end < 0 {
+				end = len(line)
+			}
+			value = line[:end]
+			line = line[end:]
+		}
+		pairs = append(pairs, pair{key, value})
+	}
+	return pairs, len(pairs) > 0
+}
+
+// closingQuote return index of the quote closing s[0]
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,62 @@
+package logs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseJSON(t *testing.T) {
+	p, err := NewParser(ParseJSON, nil, false)
+	assert.NoError(t, err)
+
+	line := `{"level":"info","trace_id":"abc","status":200,"msg":"ok"}`
+	extra, data := p.Parse(line)
+	assert.Equal(t, map[string]string{"level": "info", "trace_id": "abc"}, extra)
+	assert.Equal(t, line, data)
+
+	extra, data = p.Parse("plain text")
+	assert.Nil(t, extra)
+	assert.Equal(t, "plain text", data)
+
+	p, err = NewParser(ParseJSON, []string{"status", "msg"}, true)
+	assert.NoError(t, err)
+	extra, data = p.Parse(line)
+	assert.Equal(t, map[string]string{"status": "200", "msg": "ok"}, extra)
+	assert.Equal(t, `{"level":"info","trace_id":"abc"}`, data)
+
+	// order and html characters are kept
+	p, err = NewParser(ParseJSON, []string{"level"}, true)
+	assert.NoError(t, err)
+	extra, data = p.Parse(`{"z":"<a&b>","level":"warn","a":{"y":1,"x":[1, 2]}}`)
+	assert.Equal(t, map[string]string{"level": "warn"}, extra)
+	assert.Equal(t, `{"z":"<a&b>","a":{"y":1,"x":[1,2]}}`, data)
+}
+
+func TestParseLogfmt(t *testing.T) {
+	p, err := NewParser(ParseLogfmt, []string{"level", "request_id"}, true)
+	assert.NoError(t, err)
+
+	extra, data := p.Parse(`level=warn request_id=r1 msg="disk \"sda\" full" path=/`)
+	assert.Equal(t, map[string]string{"level": "warn", "request_id": "r1"}, extra)
+	assert.Equal(t, `msg="disk \"sda\" full" path=/`, data)
+
+	for _, line := range []string{"hello world", `msg="unterminated`, "a=1 plain"} {
+		extra, data = p.Parse(line)
+		assert.Nil(t, extra)
+		assert.Equal(t, line, data)
+	}
+}
+
+func TestParseAuto(t *testing.T) {
+	p, err := NewParser(ParseAuto, nil, false)
+	assert.NoError(t, err)
+
+	extra, _ := p.Parse(`{"level":"error"}`)
+	assert.Equal(t, "error", extra["level"])
+	extra, _ = p.Parse(`level=debug`)
+	assert.Equal(t, "debug", extra["level"])
+
+	_, err = NewParser("xml", nil, false)
+	assert.Error(t, err)
+}
@@ -0,0 +1,207 @@
+package logs
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/projecteru2/agent/types"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrSpoolFull means spool reached its size limit
+var ErrSpoolFull = errors.New("Spool full")
+
+var (
+	spooledLines = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_spooled_lines",
+		Help: "log lines spooled on disk when forward is unreachable.",
+	}, []string{"appname"})
+	spoolDroppedLines = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_spool_dropped_lines",
+		Help: "log lines dropped by spool because of size or age limit.",
+	}, []string{"appname"})
+)
+
+func init() {
+	prometheus.MustRegister(spooledLines, spoolDroppedLines)
+}
+
+type spoolEntry struct {
+	Time int64      `json:"time"`
+	Log  *types.Log `json:"log"`
+}
+
+// Spool keep log lines on disk until forward is back
+type Spool struct {
+	sync.Mutex
+	path    string
+	maxSize int64
+	maxAge  int64
+	size    int64
+	file    *os.File
+}
+
+// NewSpool return a spool for container, nil if spool is disabled
+func NewSpool(config types.SpoolConfig, ID string) (*Spool, error) {
+	if config.Dir == "" || ID == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, err
+	}
+	spool := &Spool{
+		path:    filepath.Join(config.Dir, ID+".spool"),
+		maxSize: config.MaxSize,
+		maxAge:  config.MaxAge,
+	}
+	// lines left by last run will be replayed too
+	if info, err := os.Stat(spool.path); err == nil {
+		spool.size = info.Size()
+	}
+	return spool, nil
+}
+
+// RemoveSpool remove spools of container, including those of each destination
+func RemoveSpool(config types.SpoolConfig, ID string) error {
+	if config.Dir == "" || ID == "" {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(config.Dir, ID+"*.spool"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len return bytes in spool
+func (s *Spool) Len() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.size
+}
+
+// Append save a log line to the end of spool
+func (s *Spool) Append(logline *types.Log) error {
+	data, err := json.Marshal(&spoolEntry{Time: time.Now().Unix(), Log: logline})
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.Lock()
+	defer s.Unlock()
+	if s.maxSize > 0 && s.size+int64(len(data)) > s.maxSize {
+		spoolDroppedLines.WithLabelValues(logline.Name).Inc()
+		return ErrSpoolFull
+	}
+	if s.file == nil {
+		if s.file, err = os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	spooledLines.WithLabelValues(logline.Name).Inc()
+	return nil
+}
+
+// Replay send all spooled lines to enc in order
+// lines not sent will be kept for next replay
+func (s *Spool) Replay(enc Encoder) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.size == 0 {
+		return nil
+	}
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.size = 0
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	now := time.Now().Unix()
+	reader := bufio.NewReader(f)
+	for {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		entry := &spoolEntry{}
+		if err := json.Unmarshal(data, entry); err != nil || entry.Log == nil {
+			log.Warnf("[spool] Skip broken line in %s", s.path)
+			continue
+		}
+		if s.maxAge > 0 && now-entry.Time > s.maxAge {
+			spoolDroppedLines.WithLabelValues(entry.Log.Name).Inc()
+			continue
+		}
+		if err := enc.Encode(entry.Log); err != nil {
+			return s.keep(data, reader, err)
+		}
+	}
+	s.size = 0
+	return os.Remove(s.path)
+}
+
+// keep rewrite spool with lines left, return the error made replay stop
+func (s *Spool) keep(current []byte, rest io.Reader, cause error) error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	n, err := f.Write(current)
+	if err == nil {
+		var m int64
+		m, err = io.Copy(f, rest)
+		s.size = int64(n) + m
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	return cause
+}
+
+// Close close spool file
+func (s *Spool) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
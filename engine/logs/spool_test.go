@@ -0,0 +1,80 @@
+package logs
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/projecteru2/agent/types"
+)
+
+type mockEncoder struct {
+	logs  []*types.Log
+	limit int
+}
+
+func (e *mockEncoder) Encode(logline *types.Log) error {
+	if e.limit >= 0 && len(e.logs) >= e.limit {
+		return errors.New("broken")
+	}
+	e.logs = append(e.logs, logline)
+	return nil
+}
+
+func (e *mockEncoder) Close() error {
+	return nil
+}
+
+func TestSpoolDisabled(t *testing.T) {
+	spool, err := NewSpool(types.SpoolConfig{}, "id")
+	assert.NoError(t, err)
+	assert.Nil(t, spool)
+}
+
+func TestSpoolReplay(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "spool-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	spool, err := NewSpool(types.SpoolConfig{Dir: dir}, "id")
+	assert.NoError(t, err)
+	for _, data := range []string{"a", "b", "c"} {
+		assert.NoError(t, spool.Append(&types.Log{Data: data}))
+	}
+
+	// broken after first line, the rest should be kept
+	enc := &mockEncoder{limit: 1}
+	assert.Error(t, spool.Replay(enc))
+	assert.Len(t, enc.logs, 1)
+	assert.True(t, spool.Len() > 0)
+
+	enc = &mockEncoder{limit: -1}
+	assert.NoError(t, spool.Replay(enc))
+	assert.Len(t, enc.logs, 2)
+	assert.Equal(t, "b", enc.logs[0].Data)
+	assert.Equal(t, "c", enc.logs[1].Data)
+	assert.Equal(t, int64(0), spool.Len())
+
+	// lines left by last run
+	assert.NoError(t, spool.Append(&types.Log{Data: "d"}))
+	assert.NoError(t, spool.Close())
+	spool, err = NewSpool(types.SpoolConfig{Dir: dir}, "id")
+	assert.NoError(t, err)
+	enc = &mockEncoder{limit: -1}
+	assert.NoError(t, spool.Replay(enc))
+	assert.Len(t, enc.logs, 1)
+}
+
+func TestSpoolFull(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "spool-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	spool, err := NewSpool(types.SpoolConfig{Dir: dir, MaxSize: 200}, "id")
+	assert.NoError(t, err)
+	assert.NoError(t, spool.Append(&types.Log{}))
+	assert.Equal(t, ErrSpoolFull, spool.Append(&types.Log{}))
+}
@@ -0,0 +1,193 @@
+package logs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/projecteru2/agent/common"
+	"github.com/projecteru2/agent/types"
+)
+
+const (
+	// SyslogRFC5424 for RFC 5424 format
+	SyslogRFC5424 = "rfc5424"
+	// SyslogRFC3164 for RFC 3164 (BSD) format
+	SyslogRFC3164 = "rfc3164"
+
+	// structured data id for extra fields, 32473 is the example enterprise number
+	syslogSDID = "extra@32473"
+
+	syslogSeverityErr  = 3
+	syslogSeverityInfo = 6
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3,
+	"auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogEncoder encode log as syslog message
+type SyslogEncoder struct {
+	wt       io.WriteCloser
+	hostname string
+	format   string
+	facility int
+	framing  bool
+}
+
+// NewSyslogEncoder return a syslog encoder
+// framing means transport is a stream, every message need to be framed
+func NewSyslogEncoder(wt io.WriteCloser, format, facility string, framing bool) (*SyslogEncoder, error) {
+	if format == "" {
+		format = SyslogRFC5424
+	}
+	if format != SyslogRFC5424 && format != SyslogRFC3164 {
+		return nil, fmt.Errorf("[syslog] Invalid format: %s", format)
+	}
+	if facility == "" {
+		facility = "user"
+	}
+	f, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("[syslog] Invalid facility: %s", facility)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	return &SyslogEncoder{
+		wt:       wt,
+		hostname: hostname,
+		format:   format,
+		facility: f,
+		framing:  framing,
+	}, nil
+}
+
+// Encode .
+func (e *SyslogEncoder) Encode(logline *types.Log) error {
+	var msg []byte
+	if e.format == SyslogRFC3164 {
+		msg = e.rfc3164(logline)
+	} else {
+		msg = e.rfc5424(logline)
+	}
+	// one write per message, so stdout and stderr won't mix up
+	_, err := e.wt.Write(msg)
+	return err
+}
+
+// Close .
+func (e *SyslogEncoder) Close() error {
+	return e.wt.Close()
+}
+
+func (e *SyslogEncoder) priority(logline *types.Log) int {
+	severity := syslogSeverityInfo
+	if logline.Type == "stderr" {
+		severity = syslogSeverityErr
+	}
+	return e.facility*8 + severity
+}
+
+func (e *SyslogEncoder) rfc5424(logline *types.Log) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "<%d>1 %s %s %s %s %s ",
+		e.priority(logline),
+		logTime(logline).Format("2006-01-02T15:04:05.999999Z07:00"),
+		syslogHeaderField(e.hostname, 255),
+		syslogHeaderField(logline.Name, 48),
+		syslogHeaderField(logline.Ident, 128),
+		syslogHeaderField(logline.EntryPoint, 32),
+	)
+	writeStructuredData(buf, logline.Extra)
+	if logline.Data != "" {
+		buf.WriteByte(' ')
+		buf.WriteString(logline.Data)
+	}
+	return e.frame(buf.Bytes())
+}
+
+// frame message by octet counting, RFC 6587
+// data may have newlines after multiline merged, so newline can't be the delimiter
+func (e *SyslogEncoder) frame(msg []byte) []byte {
+	if !e.framing {
+		return msg
+	}
+	return append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+}
+
+func (e *SyslogEncoder) rfc3164(logline *types.Log) []byte {
+	tag := syslogHeaderField(logline.Name, 32)
+	if logline.Ident != "" {
+		tag = fmt.Sprintf("%s[%s]", tag, syslogHeaderField(logline.Ident, 128))
+	}
+	msg := fmt.Sprintf("<%d>%s %s %s: %s",
+		e.priority(logline),
+		logTime(logline).Format(time.Stamp),
+		syslogHeaderField(e.hostname, 255),
+		tag,
+		logline.Data,
+	)
+	return e.frame([]byte(msg))
+}
+
+func logTime(logline *types.Log) time.Time {
+	t, err := time.ParseInLocation(common.DateTimeFormat, logline.Datetime, time.Local)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
+
+// header fields only allow printable ascii without space
+func syslogHeaderField(s string, max int) string {
+	if s == "" {
+		return "-"
+	}
+	field := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, s)
+	if len(field) > max {
+		field = field[:max]
+	}
+	return field
+}
+
+func writeStructuredData(buf *bytes.Buffer, extra map[string]string) {
+	if len(extra) == 0 {
+		buf.WriteByte('-')
+		return
+	}
+	keys := make([]string, 0, len(extra))
+	for k := range extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf.WriteString("[" + syslogSDID)
+	for _, k := range keys {
+		name := strings.Map(func(r rune) rune {
+			if r < 33 || r > 126 || r == '=' || r == ']' || r == '"' {
+				return '_'
+			}
+			return r
+		}, k)
+		if len(name) > 32 {
+			name = name[:32]
+		}
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(extra[k])
+		fmt.Fprintf(buf, ` %s="%s"`, name, value)
+	}
+	buf.WriteByte(']')
+}
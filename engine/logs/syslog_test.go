@@ -0,0 +1,92 @@
+package logs
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/projecteru2/agent/types"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (b *bufferCloser) Close() error {
+	return nil
+}
+
+func TestSyslogRFC5424(t *testing.T) {
+	buf := &bufferCloser{}
+	enc, err := NewSyslogEncoder(buf, "", "local0", false)
+	assert.NoError(t, err)
+	enc.hostname = "host"
+
+	err = enc.Encode(&types.Log{
+		Name:       "app",
+		Type:       "stderr",
+		EntryPoint: "web",
+		Ident:      "abc",
+		Data:       "hello world",
+		Datetime:   "2020-03-01 10:00:00.123",
+		Extra:      map[string]string{"b": `x"y]`, "a": "1"},
+	})
+	assert.NoError(t, err)
+	msg := buf.String()
+	assert.True(t, strings.HasPrefix(msg, "<131>1 2020-03-01T10:00:00.123"))
+	assert.True(t, strings.HasSuffix(msg, ` host app abc web [extra@32473 a="1" b="x\"y\]"] hello world`))
+
+	buf.Reset()
+	err = enc.Encode(&types.Log{Type: "stdout", Data: "hi"})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(buf.String(), " host - - - - hi"))
+}
+
+func TestSyslogRFC3164(t *testing.T) {
+	buf := &bufferCloser{}
+	enc, err := NewSyslogEncoder(buf, SyslogRFC3164, "", true)
+	assert.NoError(t, err)
+	enc.hostname = "host"
+
+	err = enc.Encode(&types.Log{
+		Name:     "app",
+		Type:     "stdout",
+		Ident:    "abc",
+		Data:     "hello\nworld",
+		Datetime: "2020-03-01 10:00:00.123",
+	})
+	assert.NoError(t, err)
+	// merged lines stay in one message
+	assert.Equal(t, "46 <14>Mar  1 10:00:00 host app[abc]: hello\nworld", buf.String())
+}
+
+func TestSyslogInvalid(t *testing.T) {
+	_, err := NewSyslogEncoder(&bufferCloser{}, "rfc1", "", false)
+	assert.Error(t, err)
+	_, err = NewSyslogEncoder(&bufferCloser{}, "", "nobody", false)
+	assert.Error(t, err)
+}
+
+func TestSyslogWithTCP(t *testing.T) {
+	tcpL, err := net.Listen("tcp", "127.0.0.1:34568")
+	assert.NoError(t, err)
+	defer tcpL.Close()
+
+	// no pre-connect, so the only conn accepted is ours
+	f := &forward{addr: "127.0.0.1:34568", scheme: "syslog+tcp"}
+	enc, err := f.createSyslogEncoder()
+	assert.NoError(t, err)
+	defer enc.Close()
+	conn, err := tcpL.Accept()
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.NoError(t, enc.Encode(&types.Log{Name: "app", Data: "hello"}))
+	frame, err := bufio.NewReader(conn).ReadString(' ')
+	assert.NoError(t, err)
+	assert.NotEqual(t, "0 ", frame)
+}
@@ -0,0 +1,212 @@
+package logs
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/projecteru2/agent/types"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// lines buffered for each destination
+const teeQueueSize = 1024
+
+var teeDroppedLines = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "log_tee_dropped_lines",
+	Help: "log lines dropped because destination is too slow.",
+}, []string{"appname", "forward"})
+
+func init() {
+	prometheus.MustRegister(teeDroppedLines)
+}
+
+// Tee send logs to several forwards
+// each forward has its own writer and queue, so a slow or broken one won't block the others
+type Tee struct {
+	sync.RWMutex
+	dests  []*destination
+	id     string
+	config types.LogConfig
+}
+
+type destination struct {
+	writer *Writer
+	queue  chan *types.Log
+	done   chan struct{}
+}
+
+// NewTee return a tee for container
+// each destination is a list of forwards, the first is primary and others are for fail over
+func NewTee(dests [][]string, ID string, config types.LogConfig) (*Tee, error) {
+	t := &Tee{id: ID, config: config}
+	for _, addrs := range dests {
+		dest, err := t.newDestination(addrs, len(dests))
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		t.dests = append(t.dests, dest)
+	}
+	t.start()
+	return t, nil
+}
+
+func (t *Tee) newDestination(addrs []string, count int) (*destination, error) {
+	spoolID := t.id
+	if count > 1 && t.id != "" && len(addrs) > 0 {
+		// spool of each destination must not be mixed
+		h := fnv.New32a()
+		h.Write([]byte(addrs[0]))
+		spoolID = fmt.Sprintf("%s.%x", t.id, h.Sum32())
+	}
+	writer, err := NewFailoverWriter(addrs, spoolID, t.config)
+	if err != nil {
+		return nil, err
+	}
+	return &destination{writer: writer}, nil
+}
+
+// start pumps, with only one destination it's written directly
+func (t *Tee) start() {
+	if len(t.dests) < 2 {
+		return
+	}
+	for _, dest := range t.dests {
+		if dest.queue == nil {
+			dest.queue = make(chan *types.Log, teeQueueSize)
+			dest.done = make(chan struct{})
+			go dest.pump()
+		}
+	}
+}
+
+// Forwards return forwards in use
+func (t *Tee) Forwards() []string {
+	t.RLock()
+	defer t.RUnlock()
+	forwards := []string{}
+	for _, dest := range t.dests {
+		forwards = append(forwards, dest.writer.Forward())
+	}
+	return forwards
+}
+
+// SetForwards change forwards of each destination
+// if number of destinations changed, destinations are matched by primary forward
+// the unmatched are closed, new ones are created
+func (t *Tee) SetForwards(dests [][]string, config types.TLSConfig) error {
+	t.Lock()
+	defer t.Unlock()
+	t.config.TLS = config
+	if len(dests) == len(t.dests) {
+		for i, addrs := range dests {
+			if err := t.dests[i].writer.SetForwards(addrs, config); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	log.Warnf("[tee] Destinations of %s changed from %d to %d", t.id, len(t.dests), len(dests))
+	old := map[string]*destination{}
+	for _, dest := range t.dests {
+		if primary := dest.writer.primary(); old[primary] == nil {
+			old[primary] = dest
+		}
+	}
+	kept := map[*destination]bool{}
+	created := []*destination{}
+	next := []*destination{}
+	for _, addrs := range dests {
+		primary := Discard
+		if len(addrs) > 0 {
+			primary = addrs[0]
+		}
+		if dest, ok := old[primary]; ok && !kept[dest] {
+			kept[dest] = true
+			next = append(next, dest)
+			continue
+		}
+		dest, err := t.newDestination(addrs, len(dests))
+		if err != nil {
+			for _, dest := range created {
+				go dest.close()
+			}
+			return err
+		}
+		created = append(created, dest)
+		next = append(next, dest)
+	}
+	for i, dest := range next {
+		if kept[dest] {
+			if err := dest.writer.SetForwards(dests[i], config); err != nil {
+				log.Errorf("[tee] Change forwards of %s failed %s", t.id, err)
+			}
+		}
+	}
+	for _, dest := range t.dests {
+		if !kept[dest] {
+			// let it drain without blocking writes
+			go dest.close()
+		} else if len(next) < 2 {
+			dest.stop()
+		}
+	}
+	t.dests = next
+	t.start()
+	return nil
+}
+
+// Write write log to every forward
+// with only one forward it's written directly, so error can be returned
+func (t *Tee) Write(logline *types.Log) error {
+	t.RLock()
+	defer t.RUnlock()
+	if len(t.dests) == 1 {
+		return t.dests[0].writer.Write(logline)
+	}
+	for _, dest := range t.dests {
+		select {
+		case dest.queue <- logline:
+		default:
+			teeDroppedLines.WithLabelValues(logline.Name, dest.writer.Forward()).Inc()
+		}
+	}
+	return nil
+}
+
+// Close wait for queued logs and close writers
+func (t *Tee) Close() error {
+	t.Lock()
+	defer t.Unlock()
+	for _, dest := range t.dests {
+		dest.close()
+	}
+	return nil
+}
+
+// stop pump after queued logs written
+func (d *destination) stop() {
+	if d.queue == nil {
+		return
+	}
+	close(d.queue)
+	<-d.done
+	d.queue = nil
+}
+
+func (d *destination) close() {
+	d.stop()
+	d.writer.Close()
+}
+
+func (d *destination) pump() {
+	defer close(d.done)
+	for logline := range d.queue {
+		if err := d.writer.Write(logline); err != nil {
+			log.Debugf("[tee] Write log to %s failed %v", d.writer.Forward(), err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package logs
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/projecteru2/agent/types"
+)
+
+func TestTee(t *testing.T) {
+	conns := []net.PacketConn{}
+	addrs := [][]string{}
+	for i := 0; i < 2; i++ {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		defer conn.Close()
+		conns = append(conns, conn)
+		addrs = append(addrs, []string{"udp://" + conn.LocalAddr().String()})
+	}
+	dir, err := ioutil.TempDir(os.TempDir(), "spool-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tee, err := NewTee(addrs, "id", types.LogConfig{Spool: types.SpoolConfig{Dir: dir}})
+	assert.NoError(t, err)
+	assert.Len(t, tee.dests, 2)
+	assert.NotEqual(t, tee.dests[0].writer.spool.path, tee.dests[1].writer.spool.path)
+	assert.Equal(t, []string{addrs[0][0], addrs[1][0]}, tee.Forwards())
+
+	// wait for pre-connect
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, tee.Write(&types.Log{Name: "app", Data: "hello"}))
+	for _, conn := range conns {
+		buf := make([]byte, 1024)
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		assert.NoError(t, err)
+		assert.Contains(t, string(buf[:n]), `"data":"hello"`)
+	}
+	assert.NoError(t, tee.Close())
+
+	_, err = NewTee([][]string{{"://"}}, "id", types.LogConfig{})
+	assert.Error(t, err)
+}
+
+func TestTeeSingle(t *testing.T) {
+	tee, err := NewTee([][]string{{Discard}}, "id", types.LogConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, tee.dests[0].queue)
+	assert.NoError(t, tee.Write(&types.Log{}))
+	assert.NoError(t, tee.Close())
+}
+
+func TestTeeSetForwards(t *testing.T) {
+	addrs := []string{}
+	for i := 0; i < 3; i++ {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		defer conn.Close()
+		addrs = append(addrs, "udp://"+conn.LocalAddr().String())
+	}
+	tee, err := NewTee([][]string{{addrs[0]}}, "id", types.LogConfig{})
+	assert.NoError(t, err)
+	first := tee.dests[0]
+
+	// route added, the existing destination is kept
+	assert.NoError(t, tee.SetForwards([][]string{{addrs[0]}, {addrs[1]}}, types.TLSConfig{}))
+	assert.Equal(t, []string{addrs[0], addrs[1]}, tee.Forwards())
+	assert.Equal(t, first, tee.dests[0])
+	assert.NotNil(t, first.queue)
+	assert.NoError(t, tee.Write(&types.Log{Name: "app"}))
+
+	// route removed, back to direct write
+	assert.NoError(t, tee.SetForwards([][]string{{addrs[1], addrs[2]}}, types.TLSConfig{}))
+	assert.Equal(t, []string{addrs[1]}, tee.Forwards())
+	assert.Nil(t, tee.dests[0].queue)
+	assert.NoError(t, tee.Close())
+}
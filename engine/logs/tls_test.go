@@ -0,0 +1,44 @@
+package logs
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/projecteru2/agent/types"
+)
+
+func TestNewWriterWithTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "https://")
+
+	ca, err := ioutil.TempFile(os.TempDir(), "ca-")
+	assert.NoError(t, err)
+	defer os.Remove(ca.Name())
+	pem.Encode(ca, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	ca.Close()
+
+	// unknown authority
+	f := &forward{addr: addr, scheme: "tls"}
+	f.tls, err = NewTLSConfig(types.TLSConfig{}, addr)
+	assert.NoError(t, err)
+	_, err = f.createTLSEncoder()
+	assert.Error(t, err)
+
+	f.tls, err = NewTLSConfig(types.TLSConfig{CA: ca.Name()}, addr)
+	assert.NoError(t, err)
+	enc, err := f.createTLSEncoder()
+	assert.NoError(t, err)
+	assert.NoError(t, enc.Encode(&types.Log{}))
+	enc.Close()
+
+	_, err = NewWriter("tls://"+addr, "", types.LogConfig{TLS: types.TLSConfig{CA: "/nonexist"}})
+	assert.Error(t, err)
+}
@@ -1,10 +1,12 @@
 package logs
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,14 +20,35 @@ const Discard = "__discard__"
 // ErrConnecting means writer is in connecting status, waiting to be connected
 var ErrConnecting = errors.New("Connecting")
 
+// how often to probe primary forward when failed over
+const failbackInterval = time.Minute
+
+// spiller is encoder which may fail lines after Encode returned, e.g. http
+type spiller interface {
+	SetSpill(func([]*types.Log))
+}
+
 // Writer is a writer!
+// forwards are tried in order, the first one is primary
 type Writer struct {
 	sync.Mutex
-	addr       string
-	scheme     string
+	forwards   []*forward
+	current    int
+	probing    bool
+	probed     time.Time
 	connecting bool
 	stdout     bool
 	enc        Encoder
+	spool      *Spool
+}
+
+type forward struct {
+	raw    string
+	addr   string
+	scheme string
+	path   string
+	query  url.Values
+	tls    *tls.Config
 }
 
 type discard struct {
@@ -42,89 +65,274 @@ func (d discard) Close() error {
 }
 
 // NewWriter return writer
-func NewWriter(addr string, stdout bool) (*Writer, error) {
-	if addr == Discard {
+func NewWriter(addr, ID string, config types.LogConfig) (*Writer, error) {
+	return NewFailoverWriter([]string{addr}, ID, config)
+}
+
+// NewFailoverWriter return writer which fails over to next forward when current one is down
+func NewFailoverWriter(addrs []string, ID string, config types.LogConfig) (*Writer, error) {
+	if len(addrs) == 0 || addrs[0] == Discard {
 		return &Writer{
 			enc: NewStreamEncoder(discard{}),
 		}, nil
 	}
-	u, err := url.Parse(addr)
+	writer := &Writer{stdout: config.Stdout}
+	for _, addr := range addrs {
+		f, err := parseForward(addr, config.TLS)
+		if err != nil {
+			return nil, err
+		}
+		writer.forwards = append(writer.forwards, f)
+	}
+	spool, err := NewSpool(config.Spool, ID)
 	if err != nil {
-		return nil, err
+		log.Errorf("[writer] Create spool failed %s", err)
 	}
-	writer := &Writer{addr: u.Host, scheme: u.Scheme, stdout: stdout}
+	writer.spool = spool
 	// pre-connect and ignore error
 	writer.checkConn()
-	return writer, err
+	return writer, nil
+}
+
+func parseForward(addr string, config types.TLSConfig) (*forward, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	f := &forward{raw: addr, addr: u.Host, scheme: u.Scheme, path: u.Path, query: u.Query()}
+	switch u.Scheme {
+	case "tls", "syslog+tls", "https":
+		if f.tls, err = NewTLSConfig(config, u.Host); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Forward return forward in use
+func (w *Writer) Forward() string {
+	w.Lock()
+	defer w.Unlock()
+	if len(w.forwards) == 0 {
+		return Discard
+	}
+	return w.forwards[w.current].raw
+}
+
+// primary return first forward configured
+func (w *Writer) primary() string {
+	w.Lock()
+	defer w.Unlock()
+	if len(w.forwards) == 0 {
+		return Discard
+	}
+	return w.forwards[0].raw
 }
 
 // CreateConn create conn
-func (w *Writer) createEncoder() (enc Encoder, err error) {
-	switch w.scheme {
+func (f *forward) createEncoder() (enc Encoder, err error) {
+	switch f.scheme {
 	case "udp":
-		enc, err = w.createUDPEncoder()
+		enc, err = f.createUDPEncoder()
 	case "tcp":
-		enc, err = w.createTCPEncoder()
+		enc, err = f.createTCPEncoder()
+	case "tls":
+		enc, err = f.createTLSEncoder()
 	case "journal":
 		enc, err = CreateJournalEncoder()
+	case "syslog+udp", "syslog+tcp", "syslog+tls":
+		enc, err = f.createSyslogEncoder()
+	case "kafka":
+		enc, err = f.createKafkaEncoder()
+	case "http", "https":
+		enc, err = f.createHTTPEncoder()
 	default:
-		err = fmt.Errorf("[writer] Invalid scheme: %s", w.scheme)
+		err = fmt.Errorf("[writer] Invalid scheme: %s", f.scheme)
 	}
 	return enc, err
 }
 
-func (w *Writer) checkError(err error) {
+// checkError drop encoder failed, unless it's replaced already
+func (w *Writer) checkError(enc Encoder, err error) {
 	if err != nil && err != ErrConnecting {
 		w.Lock()
-		defer w.Unlock()
 		log.Errorf("[writer] Sending log failed %s", err)
-		if w.enc != nil {
-			w.enc.Close()
+		if enc != nil && w.enc == enc {
 			w.enc = nil
+		} else {
+			// closed by whom replaced it
+			enc = nil
+		}
+		w.Unlock()
+		if enc != nil {
+			closeEncoder(enc)
 		}
 	}
 }
 
-func (w *Writer) checkConn() error {
+// createEncoder connect to forward, lines failed later go to spool
+func (w *Writer) createEncoder(f *forward) (Encoder, error) {
+	enc, err := f.createEncoder()
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := enc.(spiller); ok {
+		s.SetSpill(w.spill)
+	}
+	return enc, nil
+}
+
+func (w *Writer) spill(lines []*types.Log) {
+	if w.spool == nil {
+		log.Warnf("[writer] %d lines dropped", len(lines))
+		return
+	}
+	for _, logline := range lines {
+		if err := w.spool.Append(logline); err != nil {
+			log.Errorf("[writer] Spool log failed %s", err)
+		}
+	}
+}
+
+// closeEncoder close encoder without holding lock, it may take a while to hand back lines
+func closeEncoder(enc Encoder) {
+	go enc.Close()
+}
+
+// checkConn return encoder in use, it may be swapped once lock released
+func (w *Writer) checkConn() (Encoder, error) {
 	w.Lock()
 	defer w.Unlock()
 	if w.enc != nil {
-		// normal
-		return nil
+		if w.current != 0 && !w.probing && time.Since(w.probed) > failbackInterval {
+			w.probing = true
+			go w.probe(w.forwards[0])
+		}
+		// normal, but lines spooled must go first
+		if err := w.replay(); err != nil {
+			return nil, err
+		}
+		return w.enc, nil
 	}
 	if w.connecting == false {
 		// double check
 		if w.connecting == true {
-			return ErrConnecting
+			return nil, ErrConnecting
 		}
 		w.connecting = true
-		go func() {
-			log.Debugf("[writer] Begin trying to connect to %s", w.addr)
-			// retrying up to 4 times to prevent infinite loop
-			for i := 0; i < 4; i++ {
-				enc, err := w.createEncoder()
-				if err == nil {
-					w.Lock()
-					w.enc = enc
-					w.connecting = false
-					w.Unlock()
-					break
-				} else {
-					log.Warnf("[writer] Failed to connect to %s: %s", w.addr, err)
-					time.Sleep(30 * time.Second)
-				}
-			}
-			if w.enc == nil {
-				log.Warnf("[writer] Connect to %s failed for 4 times", w.addr)
-				w.Lock()
-				w.connecting = false
-				w.Unlock()
-			} else {
-				log.Debugf("[writer] Connect to %s successfully", w.addr)
-			}
-		}()
-	}
-	return ErrConnecting
+		go w.connect()
+	}
+	return nil, ErrConnecting
+}
+
+// connect try forwards one by one from current
+// retrying up to 4 rounds to prevent infinite loop
+func (w *Writer) connect() {
+	w.Lock()
+	forwards, current := w.forwards, w.current
+	w.Unlock()
+	for i := 0; i < 4*len(forwards); i++ {
+		if i > 0 && i%len(forwards) == 0 {
+			time.Sleep(30 * time.Second)
+		}
+		index := (current + i) % len(forwards)
+		f := forwards[index]
+		log.Debugf("[writer] Begin trying to connect to %s", f.addr)
+		enc, err := w.createEncoder(f)
+		if err != nil {
+			log.Warnf("[writer] Failed to connect to %s: %s", f.addr, err)
+			continue
+		}
+		w.Lock()
+		if !sameForwards(forwards, w.forwards) {
+			// forwards changed, connect again
+			closeEncoder(enc)
+			w.connecting = false
+			w.Unlock()
+			return
+		}
+		if index != w.current {
+			log.Warnf("[writer] Fail over from %s to %s", w.forwards[w.current].addr, f.addr)
+			w.current = index
+			w.probed = time.Now()
+		}
+		w.enc = enc
+		w.connecting = false
+		w.replay()
+		w.Unlock()
+		log.Debugf("[writer] Connect to %s successfully", f.addr)
+		return
+	}
+	log.Warnf("[writer] Connect to all forwards failed for 4 times")
+	w.Lock()
+	w.connecting = false
+	w.Unlock()
+}
+
+// probe fail back to primary forward if it's alive
+func (w *Writer) probe(primary *forward) {
+	enc, err := w.createEncoder(primary)
+	w.Lock()
+	defer w.Unlock()
+	w.probing = false
+	w.probed = time.Now()
+	if err != nil {
+		log.Debugf("[writer] Primary forward %s still down: %s", primary.addr, err)
+		return
+	}
+	if w.enc == nil || w.current == 0 || len(w.forwards) == 0 || w.forwards[0] != primary {
+		// reconnecting or forwards changed, let connect do it
+		closeEncoder(enc)
+		return
+	}
+	log.Infof("[writer] Fail back from %s to %s", w.forwards[w.current].addr, primary.addr)
+	closeEncoder(w.enc)
+	w.enc = enc
+	w.current = 0
+}
+
+// SetForwards change forwards of writer, it will reconnect to the new primary
+func (w *Writer) SetForwards(addrs []string, config types.TLSConfig) error {
+	forwards := []*forward{}
+	for _, addr := range addrs {
+		if addr == Discard {
+			forwards = nil
+			break
+		}
+		f, err := parseForward(addr, config)
+		if err != nil {
+			return err
+		}
+		forwards = append(forwards, f)
+	}
+	w.Lock()
+	defer w.Unlock()
+	if sameForwards(forwards, w.forwards) {
+		return nil
+	}
+	log.Infof("[writer] Forwards changed to %v", addrs)
+	w.forwards = forwards
+	w.current = 0
+	if w.enc != nil {
+		closeEncoder(w.enc)
+		w.enc = nil
+	}
+	if len(forwards) == 0 {
+		w.enc = NewStreamEncoder(discard{})
+	}
+	return nil
+}
+
+func sameForwards(a, b []*forward) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].raw != b[i].raw {
+			return false
+		}
+	}
+	return true
 }
 
 // Write write log to remote
@@ -132,34 +340,123 @@ func (w *Writer) Write(logline *types.Log) error {
 	if w.stdout {
 		log.Info(logline)
 	}
-	err := w.checkConn()
+	enc, err := w.checkConn()
 	if err == nil {
-		err = w.enc.Encode(logline)
+		err = enc.Encode(logline)
+	}
+	w.checkError(enc, err)
+	if err != nil && w.spool != nil {
+		// keep it, will be sent after reconnected
+		return w.spool.Append(logline)
 	}
-	w.checkError(err)
 	return err
 }
 
-func (w *Writer) createUDPEncoder() (Encoder, error) {
-	udpAddr, err := net.ResolveUDPAddr("udp", w.addr)
+// replay send spooled lines, must be called with lock held
+func (w *Writer) replay() error {
+	if w.spool == nil || w.enc == nil {
+		return nil
+	}
+	if err := w.spool.Replay(w.enc); err != nil {
+		log.Errorf("[writer] Replay spool to %s failed %s", w.forwards[w.current].addr, err)
+		closeEncoder(w.enc)
+		w.enc = nil
+		return err
+	}
+	return nil
+}
+
+// Close close encoder and spool
+func (w *Writer) Close() error {
+	w.Lock()
+	enc := w.enc
+	w.enc = nil
+	w.Unlock()
+	// lines failed when closing are spooled
+	if enc != nil {
+		enc.Close()
+	}
+	if w.spool != nil {
+		return w.spool.Close()
+	}
+	return nil
+}
+
+func (f *forward) createUDPEncoder() (Encoder, error) {
+	conn, err := f.dialUDP()
+	if err != nil {
+		return nil, err
+	}
+	return NewStreamEncoder(conn), nil
+}
+
+func (f *forward) createTCPEncoder() (Encoder, error) {
+	conn, err := f.dialTCP()
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.DialUDP("udp", nil, udpAddr)
+	return NewStreamEncoder(conn), nil
+}
+
+func (f *forward) createTLSEncoder() (Encoder, error) {
+	conn, err := f.dialTLS()
 	if err != nil {
 		return nil, err
 	}
 	return NewStreamEncoder(conn), nil
 }
 
-func (w *Writer) createTCPEncoder() (Encoder, error) {
-	tcpAddr, err := net.ResolveTCPAddr("tcp", w.addr)
+func (f *forward) createSyslogEncoder() (Encoder, error) {
+	var conn net.Conn
+	var err error
+	switch f.scheme {
+	case "syslog+udp":
+		conn, err = f.dialUDP()
+	case "syslog+tcp":
+		conn, err = f.dialTCP()
+	case "syslog+tls":
+		conn, err = f.dialTLS()
+	}
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.DialTCP("tcp", nil, tcpAddr)
+	enc, err := NewSyslogEncoder(conn, f.query.Get("format"), f.query.Get("facility"), f.scheme != "syslog+udp")
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
-	return NewStreamEncoder(conn), nil
+	return enc, nil
+}
+
+func (f *forward) createKafkaEncoder() (Encoder, error) {
+	config, err := NewKafkaConfig(f.query)
+	if err != nil {
+		return nil, err
+	}
+	return NewKafkaEncoder(strings.Split(f.addr, ","), strings.Trim(f.path, "/"), config)
+}
+
+func (f *forward) createHTTPEncoder() (Encoder, error) {
+	endpoint := (&url.URL{Scheme: f.scheme, Host: f.addr, Path: f.path}).String()
+	return NewHTTPEncoder(endpoint, f.query, f.tls)
+}
+
+func (f *forward) dialUDP() (net.Conn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", f.addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialUDP("udp", nil, udpAddr)
+}
+
+func (f *forward) dialTCP() (net.Conn, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", f.addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialTCP("tcp", nil, tcpAddr)
+}
+
+func (f *forward) dialTLS() (net.Conn, error) {
+	return tls.Dial("tcp", f.addr, f.tls)
 }
@@ -3,6 +3,7 @@ package logs
 import (
 	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -12,10 +13,10 @@ import (
 func TestNewWriterWithUDP(t *testing.T) {
 	// udp writer
 	addr := "udp://127.0.0.1:23456"
-	w, err := NewWriter(addr, true)
+	w, err := NewWriter(addr, "", types.LogConfig{Stdout: true})
 	assert.NoError(t, err)
 
-	enc, err := w.createUDPEncoder()
+	enc, err := w.forwards[0].createUDPEncoder()
 	assert.NoError(t, err)
 
 	w.enc = enc
@@ -29,14 +30,17 @@ func TestNewWriterWithTCP(t *testing.T) {
 	assert.NoError(t, err)
 
 	defer tcpL.Close()
-	w, err := NewWriter(addr, true)
+	w, err := NewWriter(addr, "", types.LogConfig{Stdout: true})
 	assert.NoError(t, err)
 
-	enc, err := w.createTCPEncoder()
+	enc, err := w.forwards[0].createTCPEncoder()
 	assert.NoError(t, err)
 
+	// pre-connect may be setting it
+	w.Lock()
 	w.enc = enc
-	w.enc.Encode(&types.Log{})
+	w.Unlock()
+	enc.Encode(&types.Log{})
 }
 
 // func TestNewWriterWithJournal(t *testing.T) {
@@ -45,7 +49,7 @@ func TestNewWriterWithTCP(t *testing.T) {
 // 	assert.NoError(t, err)
 // 	defer enc.Close()
 
-// 	w, err := NewWriter(addr, true)
+// 	w, err := NewWriter(addr, "", types.LogConfig{Stdout: true})
 // 	assert.NoError(t, err)
 
 // 	w.enc = enc
@@ -61,3 +65,76 @@ func TestNewWriterWithTCP(t *testing.T) {
 // 	})
 // 	assert.NoError(t, err)
 // }
+
+func TestWriterFailover(t *testing.T) {
+	primary, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := primary.Addr().String()
+	primary.Close()
+	backup, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer backup.Close()
+
+	w, err := NewFailoverWriter([]string{"tcp://" + addr, "tcp://" + backup.Addr().String()}, "", types.LogConfig{})
+	assert.NoError(t, err)
+	defer w.Close()
+	for i := 0; i < 30 && w.Forward() != "tcp://"+backup.Addr().String(); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, "tcp://"+backup.Addr().String(), w.Forward())
+
+	// primary is back
+	primary, err = net.Listen("tcp", addr)
+	assert.NoError(t, err)
+	defer primary.Close()
+	w.Lock()
+	w.probed = time.Now().Add(-2 * failbackInterval)
+	w.Unlock()
+	assert.NoError(t, w.Write(&types.Log{}))
+	for i := 0; i < 30 && w.Forward() != "tcp://"+addr; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, "tcp://"+addr, w.Forward())
+}
+
+func TestWriterSetForwards(t *testing.T) {
+	w, err := NewWriter(Discard, "", types.LogConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, Discard, w.Forward())
+
+	assert.NoError(t, w.SetForwards([]string{"udp://127.0.0.1:23456"}, types.TLSConfig{}))
+	assert.Equal(t, "udp://127.0.0.1:23456", w.Forward())
+	assert.Nil(t, w.enc)
+
+	assert.NoError(t, w.SetForwards([]string{Discard}, types.TLSConfig{}))
+	assert.Equal(t, Discard, w.Forward())
+	assert.NoError(t, w.Write(&types.Log{}))
+
+	assert.Error(t, w.SetForwards([]string{"://"}, types.TLSConfig{}))
+}
+
+func TestWriterWriteWhileSetForwards(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+	addr := "udp://" + conn.LocalAddr().String()
+	w, err := NewWriter(addr, "", types.LogConfig{})
+	assert.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			w.Write(&types.Log{Name: "app", Data: "data"})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		forwards := []string{addr}
+		if i%2 == 0 {
+			forwards = []string{Discard}
+		}
+		assert.NoError(t, w.SetForwards(forwards, types.TLSConfig{}))
+	}
+	<-done
+	assert.NoError(t, w.Close())
+}
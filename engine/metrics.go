@@ -341,6 +341,32 @@ func (m *MetricsClient) DropOut(nic string, i float64) {
 	m.dropOut.WithLabelValues(nic).Set(i)
 }
 
+// Close send gauges left, flush statsd and unregister prometheus things
+func (m *MetricsClient) Close() {
+	if err := m.Send(); err != nil {
+		log.Errorf("[statsd] Flush statsd failed: %v", err)
+	}
+	if m.statsdClient != nil {
+		// flush buffered
+		m.statsdClient.Close()
+		m.statsdClient = nil
+	}
+	m.Unregister()
+}
+
+// SetStatsd change statsd addr, reconnect lazily
+func (m *MetricsClient) SetStatsd(statsd string) {
+	if m.statsd == statsd {
+		return
+	}
+	log.Infof("[statsd] Statsd changed from %s to %s", m.statsd, statsd)
+	if m.statsdClient != nil {
+		m.statsdClient.Close()
+		m.statsdClient = nil
+	}
+	m.statsd = statsd
+}
+
 // Lazy connecting
 func (m *MetricsClient) checkConn() error {
 	if m.statsdClient != nil {
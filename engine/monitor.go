@@ -2,30 +2,126 @@ package engine
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
-	types "github.com/docker/docker/api/types"
+	dockertypes "github.com/docker/docker/api/types"
 	eventtypes "github.com/docker/docker/api/types/events"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/projecteru2/agent/common"
+	"github.com/projecteru2/agent/engine/logs"
 	"github.com/projecteru2/agent/engine/status"
+	"github.com/projecteru2/agent/types"
+	"github.com/projecteru2/agent/utils"
+	"github.com/projecteru2/agent/watcher"
 	coreutils "github.com/projecteru2/core/utils"
 )
 
 var eventHandler = status.NewEventHandler()
 
+var oomKilled = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "container_oom_killed",
+	Help: "times containers killed by oom.",
+}, []string{"appname", "entrypoint"})
+
+func init() {
+	prometheus.MustRegister(oomKilled)
+}
+
+const (
+	eventRetryInterval    = time.Second
+	maxEventRetryInterval = 30 * time.Second
+	// docker unreachable for so long, give up
+	eventRetryTimeout = 10 * time.Minute
+)
+
 func (e *Engine) initMonitor() (<-chan eventtypes.Message, <-chan error) {
 	eventHandler.Handle(common.StatusStart, e.handleContainerStart)
 	eventHandler.Handle(common.StatusDie, e.handleContainerDie)
+	eventHandler.Handle(common.StatusOOM, e.handleContainerOOM)
+	eventHandler.Handle(common.StatusKill, e.handleContainerKill)
+	eventHandler.Handle(common.StatusPause, e.handleContainerPause)
+	eventHandler.Handle(common.StatusUnpause, e.handleContainerUnpause)
+	eventHandler.Handle(common.StatusHealth, e.handleContainerHealthStatus)
+	eventHandler.Handle(common.StatusRename, e.handleContainerRename)
+	eventHandler.Handle(common.StatusDestory, e.handleContainerDestroy)
 
-	ctx := context.Background()
-	f := getFilter(map[string]string{"type": eventtypes.ContainerEventType})
-	options := types.EventsOptions{Filters: f}
-	eventChan, errChan := e.docker.Events(ctx, options)
+	eventChan := make(chan eventtypes.Message)
+	errChan := make(chan error, 1)
+	go e.watchEvents(eventChan, errChan)
 	return eventChan, errChan
 }
 
+// watchEvents subscribe docker events and reconnect if docker restarted
+// events missed are fetched by since, then state is resynced by load
+func (e *Engine) watchEvents(eventChan chan<- eventtypes.Message, errChan chan<- error) {
+	// stop receiving events when shutting down
+	ctx := e.ctx
+	f := getFilter(map[string]string{"type": eventtypes.ContainerEventType})
+	since := ""
+	wait := eventRetryInterval
+	var failedAt time.Time
+	for {
+		options := dockertypes.EventsOptions{Filters: f, Since: since}
+		events, errs := e.docker.Events(ctx, options)
+		if !failedAt.IsZero() {
+			// reconnected
+			if err := e.load(); err != nil {
+				log.Errorf("[watchEvents] Resync containers failed %v", err)
+			} else {
+				log.Info("[watchEvents] Docker events reconnected")
+				failedAt = time.Time{}
+				wait = eventRetryInterval
+			}
+		}
+		err := e.forwardEvents(ctx, events, errs, eventChan, &since)
+		if ctx.Err() != nil {
+			return
+		}
+		if failedAt.IsZero() {
+			failedAt = time.Now()
+		}
+		if time.Since(failedAt) > eventRetryTimeout {
+			errChan <- err
+			return
+		}
+		log.Errorf("[watchEvents] Docker events broken %v, reconnect in %v", err, wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+		if wait *= 2; wait > maxEventRetryInterval {
+			wait = maxEventRetryInterval
+		}
+	}
+}
+
+// forwardEvents until stream broken, since is updated to just after the last event
+// the last event must not be replayed, a replayed die counts as another restart
+func (e *Engine) forwardEvents(ctx context.Context, events <-chan eventtypes.Message, errs <-chan error, eventChan chan<- eventtypes.Message, since *string) error {
+	for {
+		select {
+		case event := <-events:
+			if event.TimeNano > 0 {
+				next := event.TimeNano + 1
+				*since = fmt.Sprintf("%d.%09d", next/int64(time.Second), next%int64(time.Second))
+			}
+			select {
+			case eventChan <- event:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
 func (e *Engine) monitor(eventChan <-chan eventtypes.Message) {
 	log.Info("[monitor] Status watch start")
 	eventHandler.Watch(eventChan)
@@ -33,24 +129,28 @@ func (e *Engine) monitor(eventChan <-chan eventtypes.Message) {
 
 func (e *Engine) handleContainerStart(event eventtypes.Message) {
 	log.Debugf("[handleContainerStart] container %s start", coreutils.ShortID(event.ID))
+	e.clearOOM(event.ID, event.TimeNano)
 	container, err := e.detectContainer(event.ID)
 	if err != nil {
 		log.Errorf("[handleContainerStart] detect container failed %v", err)
 		return
 	}
+	e.recordStart(container)
 
 	if container.Running {
 		// 这货会自动退出
 		e.attach(container)
 	}
 
-	// 发现需要 health check 立刻执行
+	e.reportContainer(container)
+}
+
+// 发现需要 health check 立刻执行
+func (e *Engine) reportContainer(container *types.Container) {
 	if container.Healthy {
-		if err := e.store.SetContainerStatus(context.Background(), container, e.node); err != nil {
-			log.Errorf("[handleContainerStart] update deploy status failed %v", err)
-		}
+		e.setContainerStatus(container)
 	} else {
-		go e.checkOneContainer(container, time.Duration(e.config.HealthCheckTimeout)*time.Second)
+		go e.checkOneContainer(container, e.healthCheckTimeout())
 	}
 }
 
@@ -59,7 +159,94 @@ func (e *Engine) handleContainerDie(event eventtypes.Message) {
 	container, err := e.detectContainer(event.ID)
 	if err != nil {
 		log.Errorf("[handleContainerDie] detect container failed %v", err)
-	} else if err := e.store.SetContainerStatus(context.Background(), container, e.node); err != nil {
-		log.Errorf("[handleContainerDie] update deploy status failed %v", err)
+		return
+	}
+	exitCode, _ := strconv.Atoi(event.Actor.Attributes["exitCode"])
+	e.recordDie(container, exitCode)
+	e.markCrashLoop(container)
+	e.setContainerStatus(container)
+}
+
+func (e *Engine) handleContainerOOM(event eventtypes.Message) {
+	log.Warnf("[handleContainerOOM] container %s %s oom killed", event.Actor.Attributes["name"], coreutils.ShortID(event.ID))
+	e.recordOOM(event.ID, event.TimeNano)
+	container, err := e.detectContainer(event.ID)
+	if err != nil {
+		log.Errorf("[handleContainerOOM] detect container failed %v", err)
+		return
+	}
+	oomKilled.WithLabelValues(container.Name, container.EntryPoint).Inc()
+	e.setContainerStatus(container)
+}
+
+func (e *Engine) handleContainerKill(event eventtypes.Message) {
+	log.Infof("[handleContainerKill] container %s %s killed by signal %s", event.Actor.Attributes["name"], coreutils.ShortID(event.ID), event.Actor.Attributes["signal"])
+	e.recordKill(event.ID)
+}
+
+func (e *Engine) handleContainerPause(event eventtypes.Message) {
+	log.Debugf("[handleContainerPause] container %s pause", coreutils.ShortID(event.ID))
+	container, err := e.detectContainer(event.ID)
+	if err != nil {
+		log.Errorf("[handleContainerPause] detect container failed %v", err)
+		return
+	}
+	// paused container is not healthy
+	container.Healthy = false
+	e.setContainerStatus(container)
+}
+
+func (e *Engine) handleContainerUnpause(event eventtypes.Message) {
+	log.Debugf("[handleContainerUnpause] container %s unpause", coreutils.ShortID(event.ID))
+	container, err := e.detectContainer(event.ID)
+	if err != nil {
+		log.Errorf("[handleContainerUnpause] detect container failed %v", err)
+		return
+	}
+	e.reportContainer(container)
+}
+
+// docker health check result, only used when no eru health check
+func (e *Engine) handleContainerHealthStatus(event eventtypes.Message) {
+	log.Debugf("[handleContainerHealthStatus] container %s %s", coreutils.ShortID(event.ID), event.Action)
+	container, err := e.detectContainer(event.ID)
+	if err != nil {
+		log.Errorf("[handleContainerHealthStatus] detect container failed %v", err)
+		return
+	}
+	if container.HealthCheck != nil {
+		return
+	}
+	e.setContainerStatus(container)
+}
+
+func (e *Engine) handleContainerRename(event eventtypes.Message) {
+	name := strings.TrimPrefix(event.Actor.Attributes["name"], "/")
+	log.Infof("[handleContainerRename] container %s renamed to %s", coreutils.ShortID(event.ID), name)
+	appname, entrypoint, ident, err := utils.GetAppInfo(name)
+	if err != nil {
+		log.Errorf("[handleContainerRename] invalid name %s %v", name, err)
+		return
+	}
+	if w := e.getWriter(event.ID); w != nil {
+		w.rename(appname, entrypoint, ident)
+	}
+}
+
+// destroy clean up what agent keeps for container
+func (e *Engine) handleContainerDestroy(event eventtypes.Message) {
+	log.Debugf("[handleContainerDestroy] container %s destroy", coreutils.ShortID(event.ID))
+	if w := e.getWriter(event.ID); w != nil {
+		// spool is removed after writer closed
+		w.destroy()
+	} else if err := logs.RemoveSpool(e.config.Log.Spool, event.ID); err != nil {
+		log.Errorf("[handleContainerDestroy] remove spool failed %v", err)
+	}
+	e.forgetRestarts(event.ID)
+	e.forgetOOM(event.ID)
+	e.forgetStatus(event.ID)
+	e.forgetHealth(event.ID)
+	if watcher.LogMonitor != nil {
+		watcher.LogMonitor.Forget(event.ID)
 	}
 }
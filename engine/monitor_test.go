@@ -1,14 +1,25 @@
 package engine
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+
 	log "github.com/sirupsen/logrus"
 	// "github.com/docker/docker/api/types/network"
 
+	"github.com/projecteru2/agent/engine/logs"
+	agenttypes "github.com/projecteru2/agent/types"
 	coretypes "github.com/projecteru2/core/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -46,8 +57,78 @@ func TestMonitor(t *testing.T) {
 	n := new(coretypes.Node)
 	mockStore.On("GetNode", mock.AnythingOfType("string")).Return(n, nil)
 	mockStore.On("UpdateNode", mock.Anything).Return(nil)
-	mockStore.On("SetContainerStatus", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
 	go e.monitor(eventChan)
 	time.Sleep(3 * time.Second)
+	// pushed through queue
+	assert.Len(t, pendingIDs(e), 1)
+}
+
+func TestWatchEventsReconnect(t *testing.T) {
+	e := mockNewEngine()
+	defer e.cancel()
+	sinces := []string{}
+	docker, err := client.NewClient("http://127.0.0.1", "1.25", &http.Client{
+		Transport: transportFunc(func(req *http.Request) (*http.Response, error) {
+			switch {
+			case strings.HasSuffix(req.URL.Path, "/events"):
+				sinces = append(sinces, req.URL.Query().Get("since"))
+				if len(sinces) == 1 {
+					// docker restarted after one event
+					b, _ := json.Marshal(events.Message{ID: "id", Action: "start", TimeNano: 1500000000123456789})
+					return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(b))}, nil
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader([]byte(`{"id":"id2","Action":"die"}`)))}, nil
+			case strings.HasSuffix(req.URL.Path, "/containers/json"):
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader([]byte("[]")))}, nil
+			}
+			return nil, fmt.Errorf("unexpected request %s", req.URL.Path)
+		}),
+	}, nil)
+	assert.NoError(t, err)
+	e.docker = docker
+
+	eventChan := make(chan events.Message)
+	errChan := make(chan error, 1)
+	go e.watchEvents(eventChan, errChan)
+
+	assert.Equal(t, "id", (<-eventChan).ID)
+	select {
+	case event := <-eventChan:
+		assert.Equal(t, "id2", event.ID)
+	case <-time.After(3 * time.Second):
+		t.Fatal("not reconnected")
+	}
+	assert.Equal(t, []string{"", "1500000000.123456790"}, sinces[:2])
+}
+
+func TestHandleContainerRenameAndDestroy(t *testing.T) {
+	e := mockNewEngine()
+	dir, err := ioutil.TempDir(os.TempDir(), "spool-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	e.config.Log.Spool.Dir = dir
+
+	container := &agenttypes.Container{Name: "app", EntryPoint: "web", Ident: "abcdef"}
+	container.ID = "id"
+	tee, err := logs.NewTee([][]string{{logs.Discard}}, "", agenttypes.LogConfig{})
+	assert.NoError(t, err)
+	defer tee.Close()
+	stopped := false
+	lw, ok := e.setWriter(container, tee, func() { stopped = true })
+	assert.True(t, ok)
+
+	e.handleContainerRename(events.Message{ID: "id", Actor: events.Actor{Attributes: map[string]string{"name": "/app2_worker_123456"}}})
+	name, entrypoint, ident := lw.appInfo()
+	assert.Equal(t, []string{"app2", "worker", "123456"}, []string{name, entrypoint, ident})
+
+	e.handleContainerDestroy(events.Message{ID: "id"})
+	assert.True(t, stopped)
+	assert.True(t, lw.isDestroyed())
+
+	// not attached, spool removed directly
+	assert.NoError(t, ioutil.WriteFile(dir+"/id2.spool", []byte{}, 0644))
+	e.handleContainerDestroy(events.Message{ID: "id2"})
+	_, err = os.Stat(dir + "/id2.spool")
+	assert.True(t, os.IsNotExist(err))
 }
@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"github.com/projecteru2/agent/types"
+)
+
+// recordOOM remember container oom killed at event time
+// inspect only knows it after container exited, and forgets it on restart
+func (e *Engine) recordOOM(ID string, at int64) {
+	e.oomsMu.Lock()
+	defer e.oomsMu.Unlock()
+	if e.ooms == nil {
+		e.ooms = map[string]int64{}
+	}
+	if at > e.ooms[ID] {
+		e.ooms[ID] = at
+	}
+}
+
+// clearOOM forget oom happened before container started
+// handlers run concurrently, so a later oom is kept
+func (e *Engine) clearOOM(ID string, startedAt int64) {
+	e.oomsMu.Lock()
+	defer e.oomsMu.Unlock()
+	if at, ok := e.ooms[ID]; ok && at <= startedAt {
+		delete(e.ooms, ID)
+	}
+}
+
+// markOOM fill oom killed of container until it starts again
+func (e *Engine) markOOM(container *types.Container) {
+	e.oomsMu.Lock()
+	defer e.oomsMu.Unlock()
+	if _, ok := e.ooms[container.ID]; ok {
+		container.OOMKilled = true
+	}
+}
+
+// forgetOOM drop oom of removed container
+func (e *Engine) forgetOOM(ID string) {
+	e.oomsMu.Lock()
+	defer e.oomsMu.Unlock()
+	delete(e.ooms, ID)
+}
@@ -0,0 +1,35 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/projecteru2/agent/types"
+)
+
+func TestOOMKeptUntilStart(t *testing.T) {
+	e := &Engine{}
+	marked := func() bool {
+		container := &types.Container{}
+		container.ID = "id"
+		e.markOOM(container)
+		return container.OOMKilled
+	}
+	assert.False(t, marked())
+
+	e.recordOOM("id", 200)
+	assert.True(t, marked())
+	// kept across inspects
+	assert.True(t, marked())
+
+	// start handled late, oom after it is kept
+	e.clearOOM("id", 100)
+	assert.True(t, marked())
+	e.clearOOM("id", 300)
+	assert.False(t, marked())
+
+	e.recordOOM("id", 400)
+	e.forgetOOM("id")
+	assert.False(t, marked())
+}
@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/projecteru2/agent/types"
+	coretypes "github.com/projecteru2/core/types"
+	coreutils "github.com/projecteru2/core/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	reconcileOrphans = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "reconcile_orphans",
+		Help: "containers only in docker or only in core found by last reconciliation.",
+	}, []string{"side"})
+	reconcileRepushed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "reconcile_repushed",
+		Help: "container status pushed again because it's missing or stale in core.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(reconcileOrphans, reconcileRepushed)
+}
+
+// reconcileLoop compare docker and core periodically
+// status writes failed before will be fixed here
+func (e *Engine) reconcileLoop() {
+	tick := time.NewTicker(e.config.Reconcile.Interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			e.reconcile()
+		case <-e.ctx.Done():
+			return
+		}
+	}
+}
+
+func (e *Engine) reconcile() types.Reconciliation {
+	log.Debug("[reconcile] Reconciliation begin")
+	r := types.Reconciliation{Time: time.Now(), Repushed: []string{}, DockerOrphans: []string{}, CoreOrphans: []string{}}
+	if err := e.diff(&r); err != nil {
+		log.Errorf("[reconcile] Reconciliation failed %v", err)
+		r.Error = err.Error()
+	} else {
+		reconcileOrphans.WithLabelValues("docker").Set(float64(len(r.DockerOrphans)))
+		reconcileOrphans.WithLabelValues("core").Set(float64(len(r.CoreOrphans)))
+		reconcileRepushed.Add(float64(len(r.Repushed)))
+	}
+	e.reconcileMu.Lock()
+	e.reconciliation = r
+	e.reconcileMu.Unlock()
+	return r
+}
+
+func (e *Engine) diff(r *types.Reconciliation) error {
+	ctx, cancel := context.WithTimeout(e.ctx, e.config.Reconcile.Interval)
+	defer cancel()
+
+	containers, err := e.listContainers(true, nil)
+	if err != nil {
+		return err
+	}
+	inDocker := map[string]bool{}
+	for _, c := range containers {
+		inDocker[c.ID] = true
+	}
+	e.pruneReported(inDocker)
+	IDs, err := e.store.ListNodeContainers(ctx, e.node.Name)
+	if err != nil {
+		return err
+	}
+	inCore := map[string]bool{}
+	both := []string{}
+	for _, ID := range IDs {
+		inCore[ID] = true
+		if inDocker[ID] {
+			both = append(both, ID)
+		} else {
+			log.Warnf("[reconcile] Container %s in core but not in docker", coreutils.ShortID(ID))
+			r.CoreOrphans = append(r.CoreOrphans, ID)
+		}
+	}
+	for _, c := range containers {
+		if !inCore[c.ID] {
+			log.Warnf("[reconcile] Container %s in docker but not in core", coreutils.ShortID(c.ID))
+			r.DockerOrphans = append(r.DockerOrphans, c.ID)
+		}
+	}
+	if len(both) == 0 {
+		return nil
+	}
+
+	status, err := e.store.GetContainersStatus(ctx, both)
+	if err != nil {
+		return err
+	}
+	// expired status is missing
+	pushed := map[string]*coretypes.StatusMeta{}
+	for _, s := range status {
+		pushed[s.ID] = s
+	}
+	for _, ID := range both {
+		container, err := e.detectContainer(ID)
+		if err != nil {
+			log.Errorf("[reconcile] detect container failed %v", err)
+			continue
+		}
+		if !stale(pushed[ID], container) {
+			continue
+		}
+		log.Infof("[reconcile] Status of %s is stale in core, push again", coreutils.ShortID(ID))
+		r.Repushed = append(r.Repushed, ID)
+		// it may be same as what agent sent
+		e.forgetStatus(ID)
+		e.reportContainer(container)
+	}
+	return nil
+}
+
+// stale check if status in core differs from docker
+// healthy is decided by health check for container has one
+func stale(pushed *coretypes.StatusMeta, container *types.Container) bool {
+	if pushed == nil || pushed.Running != container.Running {
+		return true
+	}
+	return container.HealthCheck == nil && pushed.Healthy != container.Healthy
+}
+
+// Reconciliation return result of last reconciliation
+func (e *Engine) Reconciliation() types.Reconciliation {
+	e.reconcileMu.Lock()
+	defer e.reconcileMu.Unlock()
+	return e.reconciliation
+}
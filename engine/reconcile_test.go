@@ -0,0 +1,50 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	agenttypes "github.com/projecteru2/agent/types"
+	coretypes "github.com/projecteru2/core/types"
+)
+
+func TestReconcile(t *testing.T) {
+	e := mockNewEngine()
+	e.node = &coretypes.Node{Name: "node"}
+	e.config.Reconcile = agenttypes.ReconcileConfig{Interval: time.Minute}
+	docker, err := client.NewClient("http://127.0.0.1", "1.25", &http.Client{
+		Transport: transportFunc(func(req *http.Request) (*http.Response, error) {
+			if strings.HasSuffix(req.URL.Path, "/containers/json") {
+				b, _ := json.Marshal([]dockertypes.Container{{ID: "a"}, {ID: "b"}, {ID: "c"}})
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(b))}, nil
+			}
+			return mockDockerDoer(req)
+		}),
+	}, nil)
+	assert.NoError(t, err)
+	e.docker = docker
+
+	mockStore.On("ListNodeContainers", mock.Anything, "node").Return([]string{"b", "c", "d"}, nil)
+	// mocked container has no pid, it's not running
+	mockStore.On("GetContainersStatus", mock.Anything, []string{"b", "c"}).Return([]*coretypes.StatusMeta{{ID: "b"}}, nil)
+
+	r := e.reconcile()
+	assert.Empty(t, r.Error)
+	assert.Equal(t, []string{"a"}, r.DockerOrphans)
+	assert.Equal(t, []string{"d"}, r.CoreOrphans)
+	assert.Equal(t, []string{"c"}, r.Repushed)
+	assert.Equal(t, r, e.Reconciliation())
+	time.Sleep(time.Second)
+	// health checked then pushed again
+	assert.Equal(t, []string{"c"}, pendingIDs(e))
+}
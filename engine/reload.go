@@ -0,0 +1,160 @@
+package engine
+
+import (
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/projecteru2/agent/types"
+	"github.com/projecteru2/agent/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// SetConfigLoader set how to load config again when SIGHUP caught
+func (e *Engine) SetConfigLoader(loader func() (*types.Config, error)) {
+	e.loader = loader
+}
+
+// reload apply log forwards, metrics transfers, health check and log level without restart
+// others need restart
+func (e *Engine) reload() error {
+	if e.loader == nil {
+		return errors.New("config loader not set")
+	}
+	config, err := e.loader()
+	if err != nil {
+		return err
+	}
+	level, err := log.ParseLevel(config.LogLevel)
+	if err != nil {
+		return err
+	}
+
+	e.configMu.Lock()
+	old := *e.config
+	forwardsChanged := !reflect.DeepEqual(old.Log.Forwards, config.Log.Forwards)
+	transfersChanged := !reflect.DeepEqual(old.Metrics.Transfers, config.Metrics.Transfers)
+	healthCheckChanged := old.HealthCheckInterval != config.HealthCheckInterval || old.HealthCheckTimeout != config.HealthCheckTimeout
+	policyChanged := old.HealthCheckFailureThreshold != config.HealthCheckFailureThreshold ||
+		old.HealthCheckSuccessThreshold != config.HealthCheckSuccessThreshold ||
+		old.HealthCheckStartPeriod != config.HealthCheckStartPeriod
+
+	e.config.Log.Forwards = config.Log.Forwards
+	e.config.Metrics.Transfers = config.Metrics.Transfers
+	e.config.HealthCheckInterval = config.HealthCheckInterval
+	e.config.HealthCheckTimeout = config.HealthCheckTimeout
+	e.config.HealthCheckFailureThreshold = config.HealthCheckFailureThreshold
+	e.config.HealthCheckSuccessThreshold = config.HealthCheckSuccessThreshold
+	e.config.HealthCheckStartPeriod = config.HealthCheckStartPeriod
+	e.config.LogLevel = config.LogLevel
+	if forwardsChanged {
+		e.forwards = utils.NewHashBackends(config.Log.Forwards)
+	}
+	if transfersChanged {
+		e.transfers = utils.NewHashBackends(config.Metrics.Transfers)
+	}
+	current := *e.config
+	e.configMu.Unlock()
+
+	if old.LogLevel != config.LogLevel {
+		log.SetLevel(level)
+		log.Infof("[reload] Log level changed to %s", level)
+	}
+	if forwardsChanged {
+		log.Infof("[reload] Log forwards changed to %v", config.Log.Forwards)
+		e.repointWriters()
+	}
+	if policyChanged {
+		log.Infof("[reload] Health check failure threshold %d success threshold %d start period %ds", config.HealthCheckFailureThreshold, config.HealthCheckSuccessThreshold, config.HealthCheckStartPeriod)
+	}
+	if transfersChanged {
+		// metric clients pick it up on next report
+		log.Infof("[reload] Metrics transfers changed to %v", config.Metrics.Transfers)
+	}
+	if healthCheckChanged {
+		log.Infof("[reload] Health check interval %ds timeout %ds", config.HealthCheckInterval, config.HealthCheckTimeout)
+		select {
+		case e.retuneC <- struct{}{}:
+		default:
+		}
+	}
+
+	// 剩下的改了也不生效
+	config.Log.Forwards = current.Log.Forwards
+	config.Metrics.Transfers = current.Metrics.Transfers
+	if !reflect.DeepEqual(current, *config) {
+		log.Warn("[reload] Some changes need restart to take effect")
+	}
+	return nil
+}
+
+// repointWriters let attached containers use new forwards
+func (e *Engine) repointWriters() {
+	e.writersMu.Lock()
+	defer e.writersMu.Unlock()
+	TLS := e.logConfig().TLS
+	for ID, w := range e.writers {
+		if err := w.SetForwards(e.logForwards(w.snapshot()), TLS); err != nil {
+			log.Errorf("[reload] Change log forwards of %s failed %s", ID, err)
+		}
+	}
+}
+
+func (e *Engine) logConfig() types.LogConfig {
+	e.configMu.RLock()
+	defer e.configMu.RUnlock()
+	return e.config.Log
+}
+
+func (e *Engine) logBackends() *utils.HashBackends {
+	e.configMu.RLock()
+	defer e.configMu.RUnlock()
+	return e.forwards
+}
+
+func (e *Engine) transfer(ID string) string {
+	e.configMu.RLock()
+	defer e.configMu.RUnlock()
+	return e.transfers.Get(ID, 0)
+}
+
+func (e *Engine) healthCheckInterval() time.Duration {
+	e.configMu.RLock()
+	defer e.configMu.RUnlock()
+	return time.Duration(e.config.HealthCheckInterval) * time.Second
+}
+
+func (e *Engine) healthCheckTimeout() time.Duration {
+	e.configMu.RLock()
+	defer e.configMu.RUnlock()
+	return time.Duration(e.config.HealthCheckTimeout) * time.Second
+}
+
+// statusTTL read under lock, it follows health check interval
+func (e *Engine) statusTTL() time.Duration {
+	e.configMu.RLock()
+	defer e.configMu.RUnlock()
+	return e.config.StatusTTL()
+}
+
+func (e *Engine) statusKeepalive() time.Duration {
+	e.configMu.RLock()
+	defer e.configMu.RUnlock()
+	return e.config.StatusKeepalive()
+}
+
+// healthCheckPolicy fill policy of container with defaults
+func (e *Engine) healthCheckPolicy(policy types.HealthCheckPolicy) types.HealthCheckPolicy {
+	e.configMu.RLock()
+	defer e.configMu.RUnlock()
+	if policy.FailureThreshold <= 0 {
+		policy.FailureThreshold = e.config.HealthCheckFailureThreshold
+	}
+	if policy.SuccessThreshold <= 0 {
+		policy.SuccessThreshold = e.config.HealthCheckSuccessThreshold
+	}
+	if policy.StartPeriod <= 0 {
+		policy.StartPeriod = e.config.HealthCheckStartPeriod
+	}
+	return policy
+}
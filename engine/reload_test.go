@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/projecteru2/agent/engine/logs"
+	"github.com/projecteru2/agent/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReload(t *testing.T) {
+	e := mockNewEngine()
+	e.config.LogLevel = "INFO"
+	e.retuneC = make(chan struct{}, 1)
+	assert.Error(t, e.reload())
+
+	container := &types.Container{Name: "app"}
+	container.ID = "id"
+	tee, err := logs.NewTee(e.logForwards(container), "", types.LogConfig{})
+	assert.NoError(t, err)
+	defer tee.Close()
+	e.setWriter(container, tee, func() {})
+
+	e.SetConfigLoader(func() (*types.Config, error) {
+		return &types.Config{
+			LogLevel:            "INFO",
+			HealthCheckInterval: 5,
+			HealthCheckTimeout:  1,
+			Log:                 types.LogConfig{Forwards: []string{"udp://127.0.0.1:5145"}},
+			Metrics:             types.MetricsConfig{Transfers: []string{"127.0.0.1:8126"}},
+		}, nil
+	})
+	assert.NoError(t, e.reload())
+	assert.Equal(t, map[string][]string{"id": {"udp://127.0.0.1:5145"}}, e.LogForwards())
+	assert.Equal(t, "127.0.0.1:8126", e.transfer("id"))
+	assert.Equal(t, 5*time.Second, e.healthCheckInterval())
+	assert.Equal(t, time.Second, e.healthCheckTimeout())
+	assert.Len(t, e.retuneC, 1)
+
+	e.SetConfigLoader(func() (*types.Config, error) {
+		return &types.Config{LogLevel: "LOUD"}, nil
+	})
+	assert.Error(t, e.reload())
+}
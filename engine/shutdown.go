@@ -0,0 +1,47 @@
+package engine
+
+import (
+	"context"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// shutdown stop receiving events, drain logs and metrics of attached containers within deadline
+func (e *Engine) shutdown() {
+	log.Infof("[shutdown] Shutting down in %v", e.config.Shutdown.Timeout)
+	e.cancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.config.Shutdown.Timeout)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		e.attached.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Info("[shutdown] Logs and metrics drained")
+	case <-ctx.Done():
+		log.Warn("[shutdown] Drain timeout, some logs may be lost")
+	}
+	select {
+	case <-e.statuses.done:
+		e.statuses.flush(ctx)
+	case <-ctx.Done():
+	}
+	if ctx.Err() != nil {
+		log.Warn("[shutdown] Status flush timeout, some status may be lost")
+	}
+
+	if e.config.Shutdown.MarkNodeDown {
+		if err := e.activated(false); err != nil {
+			log.Errorf("[shutdown] Mark node down failed %v", err)
+		} else {
+			log.Info("[shutdown] Node marked down")
+		}
+	}
+	if err := os.Remove(e.config.PidFile); err != nil && !os.IsNotExist(err) {
+		log.Errorf("[shutdown] Remove pidfile failed %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/projecteru2/agent/types"
+	coretypes "github.com/projecteru2/core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestShutdown(t *testing.T) {
+	e := mockNewEngine()
+	e.node = &coretypes.Node{Available: true}
+	mockStore.On("UpdateNode", mock.Anything).Return(nil)
+
+	pid, err := ioutil.TempFile(os.TempDir(), "agent-pid-")
+	assert.NoError(t, err)
+	pid.Close()
+	e.config.PidFile = pid.Name()
+	e.config.Shutdown.Timeout = time.Second
+	e.config.Shutdown.MarkNodeDown = true
+
+	go e.statuses.run(e.ctx)
+	// a container still draining
+	e.attached.Add(1)
+	go func() {
+		<-e.ctx.Done()
+		time.Sleep(100 * time.Millisecond)
+		e.attached.Done()
+	}()
+	start := time.Now()
+	e.shutdown()
+	assert.True(t, time.Since(start) < time.Second)
+	assert.False(t, e.node.Available)
+	mockStore.AssertCalled(t, "UpdateNode", e.node)
+	_, err = os.Stat(pid.Name())
+	assert.True(t, os.IsNotExist(err))
+
+	// no more attaching
+	e.attach(nil)
+}
+
+func TestShutdownStatusDeadline(t *testing.T) {
+	e := mockNewEngine()
+	e.node = &coretypes.Node{}
+	e.config.PidFile = os.DevNull + ".none"
+	e.config.Shutdown.Timeout = 200 * time.Millisecond
+	// core hangs until ctx done
+	e.statuses = newStatusQueue(func(ctx context.Context, containers []*types.Container) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, types.StatusConfig{})
+	go e.statuses.run(e.ctx)
+	container := &types.Container{}
+	container.ID = "id"
+	e.setContainerStatus(container)
+
+	start := time.Now()
+	e.shutdown()
+	assert.True(t, time.Since(start) < time.Second)
+}
@@ -33,10 +33,7 @@ func (e *Engine) stat(parentCtx context.Context, container *types.Container) {
 	tick := time.NewTicker(timeout)
 	defer tick.Stop()
 	hostname := strings.Replace(e.config.HostName, ".", "-", -1)
-	addr := ""
-	if e.transfers.Len() > 0 {
-		addr = e.transfers.Get(container.ID, 0)
-	}
+	addr := e.transfer(container.ID)
 
 	period := float64(e.config.Metrics.Step)
 	hostCPUCount := e.cpuCore * period
@@ -125,6 +122,8 @@ func (e *Engine) stat(parentCtx context.Context, container *types.Container) {
 			mClient.DropOut(nic.Name, float64(nic.Dropout-oldNICStats.Dropout)/delta)
 		}
 		containerCPUStats, systemCPUStats, containerNetStats = newContainrCPUStats, newSystemCPUStats, newContainerNetStats
+		// transfers may be reloaded
+		mClient.SetStatsd(e.transfer(container.ID))
 		mClient.Send()
 	}
 	for {
@@ -132,7 +131,7 @@ func (e *Engine) stat(parentCtx context.Context, container *types.Container) {
 		case <-tick.C:
 			updateMetrics()
 		case <-parentCtx.Done():
-			mClient.Unregister()
+			mClient.Close()
 			return
 		}
 	}
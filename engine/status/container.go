@@ -1,9 +1,13 @@
 package status
 
 import (
+	"encoding/json"
+	"time"
+
 	enginetypes "github.com/docker/docker/api/types"
 	"github.com/projecteru2/agent/types"
 	"github.com/projecteru2/agent/utils"
+	"github.com/projecteru2/core/cluster"
 	coretypes "github.com/projecteru2/core/types"
 	log "github.com/sirupsen/logrus"
 )
@@ -29,15 +33,16 @@ func GenerateContainerMeta(c enginetypes.ContainerJSON, meta *coretypes.LabelMet
 	}
 
 	container := &types.Container{
-		StatusMeta:  coretypes.StatusMeta{ID: c.ID},
-		Name:        name,
-		EntryPoint:  entrypoint,
-		Ident:       ident,
-		Labels:      labels,
-		HealthCheck: meta.HealthCheck,
-		CPUQuota:    c.HostConfig.Resources.CPUQuota,
-		CPUPeriod:   c.HostConfig.Resources.CPUPeriod,
-		Memory:      utils.Max(c.HostConfig.Memory, c.HostConfig.MemoryReservation),
+		StatusMeta:        coretypes.StatusMeta{ID: c.ID},
+		Name:              name,
+		EntryPoint:        entrypoint,
+		Ident:             ident,
+		Labels:            labels,
+		HealthCheck:       meta.HealthCheck,
+		HealthCheckPolicy: decodeHealthCheckPolicy(labels),
+		CPUQuota:          c.HostConfig.Resources.CPUQuota,
+		CPUPeriod:         c.HostConfig.Resources.CPUPeriod,
+		Memory:            utils.Max(c.HostConfig.Memory, c.HostConfig.MemoryReservation),
 	}
 
 	if !c.State.Running || c.State.Pid == 0 {
@@ -49,9 +54,48 @@ func GenerateContainerMeta(c enginetypes.ContainerJSON, meta *coretypes.LabelMet
 		// 需要告诉第一次上的时候这个容器是健康的, 还是不是
 		container.Pid = c.State.Pid
 		container.Running = c.State.Running
+		if startedAt, err := time.Parse(time.RFC3339Nano, c.State.StartedAt); err == nil {
+			container.StartedAt = startedAt
+		}
 		container.Healthy = !(meta.HealthCheck != nil)
+		// 没有 eru 的 health check 就用 docker 自己的
+		if meta.HealthCheck == nil && c.State.Health != nil {
+			container.Healthy = c.State.Health.Status == enginetypes.Healthy
+		}
+		// 暂停了肯定不健康
+		if c.State.Paused {
+			container.Healthy = false
+		}
+	}
+	container.Paused = c.State.Paused
+	container.OOMKilled = c.State.OOMKilled
+	// 死了才知道为啥死
+	if !container.Running {
+		container.ExitCode = c.State.ExitCode
+		container.Error = c.State.Error
+		if finishedAt, err := time.Parse(time.RFC3339Nano, c.State.FinishedAt); err == nil && !finishedAt.IsZero() {
+			container.FinishedAt = finishedAt
+		}
 	}
 
 	log.Debugf("[GenerateContainerMeta] Generate container meta %v %v", container.Name, container.EntryPoint)
 	return container, nil
 }
+
+// thresholds core doesn't know are also in HealthCheck of label meta
+type healthCheckMeta struct {
+	HealthCheck *types.HealthCheckPolicy
+}
+
+func decodeHealthCheckPolicy(labels map[string]string) types.HealthCheckPolicy {
+	meta := &healthCheckMeta{}
+	if metastr, ok := labels[cluster.LabelMeta]; ok {
+		if err := json.Unmarshal([]byte(metastr), meta); err != nil {
+			log.Errorf("[decodeHealthCheckPolicy] Decode failed %v", err)
+		}
+	}
+	if meta.HealthCheck == nil {
+		return types.HealthCheckPolicy{}
+	}
+	return *meta.HealthCheck
+}
@@ -1,6 +1,7 @@
 package status
 
 import (
+	"strings"
 	"sync"
 
 	eventtypes "github.com/docker/docker/api/types/events"
@@ -30,8 +31,13 @@ func (e *EventHandler) Handle(action string, h func(eventtypes.Message)) {
 func (e *EventHandler) Watch(c <-chan eventtypes.Message) {
 	for ev := range c {
 		log.Infof("[Watch] Monitor: cid %s action %s", coreutils.ShortID(ev.ID), ev.Action)
+		// some actions carry detail, e.g. "health_status: healthy"
+		action := ev.Action
+		if i := strings.Index(action, ":"); i >= 0 {
+			action = action[:i]
+		}
 		e.Lock()
-		h, exists := e.handlers[ev.Action]
+		h, exists := e.handlers[action]
 		e.Unlock()
 		if !exists {
 			continue
@@ -0,0 +1,240 @@
+package engine
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/projecteru2/agent/types"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	statusRetryInterval    = time.Second
+	maxStatusRetryInterval = time.Minute
+)
+
+var statusRetries = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "container_status_retries",
+	Help: "times container status pushed again after failed.",
+})
+
+func init() {
+	prometheus.MustRegister(statusRetries)
+}
+
+// statusQueue push container status to store
+// only latest status of each container is kept, failed ones are retried with backoff
+// updates coming within window are pushed in batches
+type statusQueue struct {
+	sync.Mutex
+	pending map[string]*pendingStatus
+	kick    chan struct{}
+	done    chan struct{}
+	window  time.Duration
+	size    int
+	push    func(context.Context, []*types.Container) error
+}
+
+type pendingStatus struct {
+	container *types.Container
+	backoff   time.Duration
+	retryAt   time.Time
+}
+
+func newStatusQueue(push func(context.Context, []*types.Container) error, config types.StatusConfig) *statusQueue {
+	return &statusQueue{
+		pending: map[string]*pendingStatus{},
+		kick:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		window:  config.BatchWindow,
+		size:    config.BatchSize,
+		push:    push,
+	}
+}
+
+// put replace pending status of container
+// backoff is kept, store is still broken anyway
+func (q *statusQueue) put(container *types.Container) {
+	q.Lock()
+	if p, ok := q.pending[container.ID]; ok {
+		p.container = container
+	} else {
+		q.pending[container.ID] = &pendingStatus{container: container}
+	}
+	q.Unlock()
+	select {
+	case q.kick <- struct{}{}:
+	default:
+	}
+}
+
+// take remove due status from queue, return when next one is due
+func (q *statusQueue) take(now time.Time) ([]*pendingStatus, time.Duration) {
+	q.Lock()
+	defer q.Unlock()
+	due := []*pendingStatus{}
+	wait := maxStatusRetryInterval
+	for ID, p := range q.pending {
+		if !p.retryAt.After(now) {
+			due = append(due, p)
+			delete(q.pending, ID)
+		} else if d := p.retryAt.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	return due, wait
+}
+
+// retry put failed status back unless a newer one came
+func (q *statusQueue) retry(p *pendingStatus) {
+	if p.backoff *= 2; p.backoff == 0 {
+		p.backoff = statusRetryInterval
+	} else if p.backoff > maxStatusRetryInterval {
+		p.backoff = maxStatusRetryInterval
+	}
+	p.retryAt = time.Now().Add(p.backoff)
+	q.Lock()
+	defer q.Unlock()
+	if newer, ok := q.pending[p.container.ID]; ok {
+		newer.backoff, newer.retryAt = p.backoff, p.retryAt
+		return
+	}
+	q.pending[p.container.ID] = p
+}
+
+// batches split status into batches no larger than size
+func (q *statusQueue) batches(pending []*pendingStatus) [][]*pendingStatus {
+	batches := [][]*pendingStatus{}
+	for len(pending) > 0 {
+		n := len(pending)
+		if q.size > 0 && n > q.size {
+			n = q.size
+		}
+		batches = append(batches, pending[:n])
+		pending = pending[n:]
+	}
+	return batches
+}
+
+func containersOf(batch []*pendingStatus) []*types.Container {
+	containers := []*types.Container{}
+	for _, p := range batch {
+		containers = append(containers, p.container)
+	}
+	return containers
+}
+
+// run push status until ctx done, pending ones are left for flush
+func (q *statusQueue) run(ctx context.Context) {
+	defer close(q.done)
+	for {
+		due, wait := q.take(time.Now())
+		for _, batch := range q.batches(due) {
+			if err := q.push(ctx, containersOf(batch)); err != nil {
+				statusRetries.Add(float64(len(batch)))
+				for _, p := range batch {
+					q.retry(p)
+				}
+				log.Errorf("[statusQueue] Update status of %d containers failed %v, retry in %v", len(batch), err, batch[0].backoff)
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-q.kick:
+			// wait for more updates
+			select {
+			case <-time.After(q.window):
+			case <-ctx.Done():
+			}
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// flush try pending status once more within ctx, call it after run returned
+func (q *statusQueue) flush(ctx context.Context) {
+	q.Lock()
+	pending := []*pendingStatus{}
+	for _, p := range q.pending {
+		pending = append(pending, p)
+	}
+	q.pending = map[string]*pendingStatus{}
+	q.Unlock()
+	for _, batch := range q.batches(pending) {
+		if err := q.push(ctx, containersOf(batch)); err != nil {
+			log.Errorf("[statusQueue] Update status of %d containers failed %v, dropped", len(batch), err)
+		}
+	}
+}
+
+// pushContainersStatus bound each call, a hung core must not stop later pushes
+func (e *Engine) pushContainersStatus(ctx context.Context, containers []*types.Container) error {
+	ctx, cancel := context.WithTimeout(ctx, e.healthCheckTimeout())
+	defer cancel()
+	return e.store.SetContainersStatus(ctx, containers, e.node, e.statusTTL())
+}
+
+// last status sent of a container
+type reportedStatus struct {
+	container *types.Container
+	at        time.Time
+}
+
+// sameStatus compare fields core cares about
+func sameStatus(a, b *types.Container) bool {
+	return a.Running == b.Running &&
+		a.Healthy == b.Healthy &&
+		reflect.DeepEqual(a.Networks, b.Networks) &&
+		a.Paused == b.Paused &&
+		a.OOMKilled == b.OOMKilled &&
+		a.CrashLoop == b.CrashLoop &&
+		a.Restarts == b.Restarts &&
+		a.ExitCode == b.ExitCode &&
+		a.Error == b.Error
+}
+
+// setContainerStatus is the only way to push status to store
+// status is sent when changed, or refreshed early enough to reach core within ttl
+func (e *Engine) setContainerStatus(container *types.Container) {
+	keepalive := e.statusKeepalive()
+
+	now := time.Now()
+	e.reportedMu.Lock()
+	if e.reported == nil {
+		e.reported = map[string]*reportedStatus{}
+	}
+	last, ok := e.reported[container.ID]
+	if ok && sameStatus(last.container, container) && now.Sub(last.at) < keepalive {
+		e.reportedMu.Unlock()
+		return
+	}
+	e.reported[container.ID] = &reportedStatus{container: container, at: now}
+	e.reportedMu.Unlock()
+	e.statuses.put(container)
+}
+
+// forgetStatus make next status of container sent anyway
+func (e *Engine) forgetStatus(ID string) {
+	e.reportedMu.Lock()
+	defer e.reportedMu.Unlock()
+	delete(e.reported, ID)
+}
+
+// pruneReported forget containers removed, destroy events may be missed
+func (e *Engine) pruneReported(alive map[string]bool) {
+	e.reportedMu.Lock()
+	defer e.reportedMu.Unlock()
+	for ID := range e.reported {
+		if !alive[ID] {
+			delete(e.reported, ID)
+		}
+	}
+}
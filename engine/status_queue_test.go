@@ -0,0 +1,182 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/projecteru2/agent/types"
+	coretypes "github.com/projecteru2/core/types"
+)
+
+func TestStatusQueue(t *testing.T) {
+	var mu sync.Mutex
+	fail := true
+	pushed := []bool{}
+	q := newStatusQueue(func(ctx context.Context, containers []*types.Container) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			return errors.New("core down")
+		}
+		for _, container := range containers {
+			pushed = append(pushed, container.Running)
+		}
+		return nil
+	}, types.StatusConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go q.run(ctx)
+
+	container := &types.Container{}
+	container.ID = "id"
+	container.Running = true
+	q.put(container)
+	time.Sleep(100 * time.Millisecond)
+
+	// latest wins, backoff kept
+	died := &types.Container{}
+	died.ID = "id"
+	q.put(died)
+	q.Lock()
+	assert.Len(t, q.pending, 1)
+	assert.Equal(t, statusRetryInterval, q.pending["id"].backoff)
+	assert.Equal(t, died, q.pending["id"].container)
+	q.Unlock()
+
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	time.Sleep(1200 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []bool{false}, pushed)
+	mu.Unlock()
+
+	// pending ones are tried when stopped
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	q.put(container)
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	cancel()
+	<-q.done
+	q.flush(context.Background())
+	assert.Equal(t, []bool{false, true}, pushed)
+}
+
+func TestStatusQueueBatch(t *testing.T) {
+	var mu sync.Mutex
+	batches := []int{}
+	q := newStatusQueue(func(ctx context.Context, containers []*types.Container) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, len(containers))
+		return nil
+	}, types.StatusConfig{BatchWindow: 100 * time.Millisecond, BatchSize: 4})
+	ctx, cancel := context.WithCancel(context.Background())
+	go q.run(ctx)
+	// wait for first round with nothing
+	time.Sleep(10 * time.Millisecond)
+
+	for i := 0; i < 10; i++ {
+		container := &types.Container{}
+		container.ID = fmt.Sprintf("id%d", i)
+		q.put(container)
+	}
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	<-q.done
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []int{4, 4, 2}, batches)
+}
+
+func TestSetContainerStatusOnChange(t *testing.T) {
+	e := mockNewEngine()
+	e.config.Status.TTL = time.Minute
+	pending := func() *types.Container {
+		e.statuses.Lock()
+		defer e.statuses.Unlock()
+		p, ok := e.statuses.pending["id"]
+		if !ok {
+			return nil
+		}
+		delete(e.statuses.pending, "id")
+		return p.container
+	}
+
+	container := &types.Container{}
+	container.ID = "id"
+	container.Running = true
+	e.setContainerStatus(container)
+	assert.Equal(t, container, pending())
+
+	// unchanged
+	same := *container
+	e.setContainerStatus(&same)
+	assert.Nil(t, pending())
+
+	// transition sent immediately
+	healthy := *container
+	healthy.Healthy = true
+	e.setContainerStatus(&healthy)
+	assert.Equal(t, &healthy, pending())
+
+	// keepalive within ttl
+	e.reported["id"].at = time.Now().Add(-e.statusKeepalive())
+	e.setContainerStatus(&healthy)
+	assert.Equal(t, &healthy, pending())
+
+	e.forgetStatus("id")
+	e.setContainerStatus(&healthy)
+	assert.Equal(t, &healthy, pending())
+}
+
+func TestStatusKeepalive(t *testing.T) {
+	config := &types.Config{HealthCheckInterval: 10, HealthCheckTimeout: 3}
+	config.Status.BatchWindow = 200 * time.Millisecond
+	// default 60s leaves 13.2s for check and batch, refreshed every 5th check
+	assert.Equal(t, time.Minute, config.StatusTTL())
+	assert.Equal(t, 46800*time.Millisecond, config.StatusKeepalive())
+
+	// too small one is raised
+	config.Status.TTL = 5 * time.Second
+	assert.Equal(t, 23200*time.Millisecond, config.StatusTTL())
+	assert.Equal(t, 10*time.Second, config.StatusKeepalive())
+}
+
+func TestPushContainersStatusTimeout(t *testing.T) {
+	e := mockNewEngine()
+	e.config.HealthCheckTimeout = 1
+	// core hangs until ctx done
+	mockStore.On("SetContainersStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(func(ctx context.Context, containers []*types.Container, node *coretypes.Node, ttl time.Duration) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	start := time.Now()
+	err := e.pushContainersStatus(context.Background(), []*types.Container{{}})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < 2*time.Second)
+}
+
+// pendingIDs return containers waiting to be pushed, the queue isn't running in tests
+func pendingIDs(e *Engine) []string {
+	e.statuses.Lock()
+	defer e.statuses.Unlock()
+	IDs := []string{}
+	for ID := range e.statuses.pending {
+		IDs = append(IDs, ID)
+	}
+	sort.Strings(IDs)
+	return IDs
+}
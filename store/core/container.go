@@ -2,6 +2,8 @@ package corestore
 
 import (
 	"encoding/json"
+	"strconv"
+	"time"
 
 	"github.com/projecteru2/agent/types"
 	pb "github.com/projecteru2/core/rpc/gen"
@@ -9,25 +11,86 @@ import (
 	"golang.org/x/net/context"
 )
 
-// SetContainerStatus deploy containers
-func (c *CoreStore) SetContainerStatus(ctx context.Context, container *types.Container, node *coretypes.Node) error {
+// SetContainersStatus deploy containers in one call, status expires after ttl
+// ttl is given by caller, config may be reloaded meanwhile
+func (c *CoreStore) SetContainersStatus(ctx context.Context, containers []*types.Container, node *coretypes.Node, ttl time.Duration) error {
 	client := c.client.GetRPCClient()
-	bytes, err := json.Marshal(container.Labels)
+	opts := &pb.SetContainersStatusOptions{}
+	for _, container := range containers {
+		bytes, err := json.Marshal(extension(container))
+		if err != nil {
+			return err
+		}
+		opts.Status = append(opts.Status, &pb.ContainerStatus{
+			Id:        container.ID,
+			Running:   container.Running,
+			Healthy:   container.Healthy,
+			Networks:  container.Networks,
+			Extension: bytes,
+			Ttl:       int64(ttl / time.Second),
+		})
+	}
+	_, err := client.SetContainersStatus(ctx, opts)
+	return err
+}
+
+// ListNodeContainers return IDs of containers on node in core
+func (c *CoreStore) ListNodeContainers(ctx context.Context, nodename string) ([]string, error) {
+	client := c.client.GetRPCClient()
+	resp, err := client.ListNodeContainers(ctx, &pb.GetNodeOptions{Nodename: nodename})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	containerStatus := &pb.ContainerStatus{
-		Id:        container.ID,
-		Running:   container.Running,
-		Healthy:   container.Healthy,
-		Networks:  container.Networks,
-		Extension: bytes,
-		Ttl:       int64(2 * c.config.HealthCheckInterval + c.config.HealthCheckInterval / 2),
+	IDs := []string{}
+	for _, container := range resp.Containers {
+		IDs = append(IDs, container.Id)
 	}
+	return IDs, nil
+}
 
-	opts := &pb.SetContainersStatusOptions{
-		Status: []*pb.ContainerStatus{containerStatus},
+// GetContainersStatus return status in core, expired ones are missing
+func (c *CoreStore) GetContainersStatus(ctx context.Context, IDs []string) ([]*coretypes.StatusMeta, error) {
+	client := c.client.GetRPCClient()
+	resp, err := client.GetContainersStatus(ctx, &pb.ContainerIDs{Ids: IDs})
+	if err != nil {
+		return nil, err
 	}
-	_, err = client.SetContainersStatus(ctx, opts)
-	return err
+	status := []*coretypes.StatusMeta{}
+	for _, s := range resp.Status {
+		status = append(status, &coretypes.StatusMeta{
+			ID:        s.Id,
+			Running:   s.Running,
+			Healthy:   s.Healthy,
+			Networks:  s.Networks,
+			Extension: s.Extension,
+		})
+	}
+	return status, nil
+}
+
+// labels with status agent found
+func extension(container *types.Container) map[string]string {
+	ext := map[string]string{}
+	for k, v := range container.Labels {
+		ext[k] = v
+	}
+	if container.OOMKilled {
+		ext["eru.oom_killed"] = "true"
+	}
+	if !container.Running {
+		ext["eru.exit_code"] = strconv.Itoa(container.ExitCode)
+		if !container.FinishedAt.IsZero() {
+			ext["eru.finished_at"] = container.FinishedAt.Format(time.RFC3339Nano)
+		}
+		if container.Error != "" {
+			ext["eru.error"] = container.Error
+		}
+	}
+	if container.CrashLoop {
+		ext["eru.crashloop"] = "true"
+	}
+	if container.Restarts > 0 {
+		ext["eru.restarts"] = strconv.Itoa(container.Restarts)
+	}
+	return ext
 }
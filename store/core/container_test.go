@@ -0,0 +1,32 @@
+package corestore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/projecteru2/agent/types"
+)
+
+func TestExtension(t *testing.T) {
+	container := &types.Container{
+		Labels:     map[string]string{"a": "b"},
+		OOMKilled:  true,
+		ExitCode:   137,
+		FinishedAt: time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC),
+		Error:      "oops",
+	}
+	assert.Equal(t, map[string]string{
+		"a":               "b",
+		"eru.oom_killed":  "true",
+		"eru.exit_code":   "137",
+		"eru.finished_at": "2020-01-02T03:04:05.000000006Z",
+		"eru.error":       "oops",
+	}, extension(container))
+	assert.Equal(t, map[string]string{"a": "b"}, container.Labels)
+
+	container = &types.Container{}
+	container.Running = true
+	assert.Empty(t, extension(container))
+}
@@ -9,6 +9,8 @@ import (
 
 	mock "github.com/stretchr/testify/mock"
 
+	time "time"
+
 	types "github.com/projecteru2/core/types"
 )
 
@@ -40,13 +42,59 @@ func (_m *Store) GetNode(nodename string) (*types.Node, error) {
 	return r0, r1
 }
 
-// SetContainerStatus provides a mock function with given fields: _a0, _a1, _a2
-func (_m *Store) SetContainerStatus(_a0 context.Context, _a1 *agenttypes.Container, _a2 *types.Node) error {
-	ret := _m.Called(_a0, _a1, _a2)
+// GetContainersStatus provides a mock function with given fields: ctx, IDs
+func (_m *Store) GetContainersStatus(ctx context.Context, IDs []string) ([]*types.StatusMeta, error) {
+	ret := _m.Called(ctx, IDs)
+
+	var r0 []*types.StatusMeta
+	if rf, ok := ret.Get(0).(func(context.Context, []string) []*types.StatusMeta); ok {
+		r0 = rf(ctx, IDs)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*types.StatusMeta)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []string) error); ok {
+		r1 = rf(ctx, IDs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListNodeContainers provides a mock function with given fields: ctx, nodename
+func (_m *Store) ListNodeContainers(ctx context.Context, nodename string) ([]string, error) {
+	ret := _m.Called(ctx, nodename)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(context.Context, string) []string); ok {
+		r0 = rf(ctx, nodename)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, nodename)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetContainersStatus provides a mock function with given fields: _a0, _a1, _a2, _a3
+func (_m *Store) SetContainersStatus(_a0 context.Context, _a1 []*agenttypes.Container, _a2 *types.Node, _a3 time.Duration) error {
+	ret := _m.Called(_a0, _a1, _a2, _a3)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, *agenttypes.Container, *types.Node) error); ok {
-		r0 = rf(_a0, _a1, _a2)
+	if rf, ok := ret.Get(0).(func(context.Context, []*agenttypes.Container, *types.Node, time.Duration) error); ok {
+		r0 = rf(_a0, _a1, _a2, _a3)
 	} else {
 		r0 = ret.Error(0)
 	}
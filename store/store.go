@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"time"
 
 	"github.com/projecteru2/agent/types"
 	coretypes "github.com/projecteru2/core/types"
@@ -12,5 +13,7 @@ type Store interface {
 	GetNode(nodename string) (*coretypes.Node, error)
 	UpdateNode(node *coretypes.Node) error
 
-	SetContainerStatus(context.Context, *types.Container, *coretypes.Node) error
+	SetContainersStatus(context.Context, []*types.Container, *coretypes.Node, time.Duration) error
+	ListNodeContainers(ctx context.Context, nodename string) ([]string, error)
+	GetContainersStatus(ctx context.Context, IDs []string) ([]*coretypes.StatusMeta, error)
 }
@@ -2,6 +2,7 @@ package types
 
 import (
 	"os"
+	"time"
 
 	coretypes "github.com/projecteru2/core/types"
 	log "github.com/sirupsen/logrus"
@@ -24,26 +25,141 @@ type APIConfig struct {
 	Addr string `yaml:"addr"`
 }
 
+// SpoolConfig contain log spool config
+type SpoolConfig struct {
+	Dir     string `yaml:"dir"`
+	MaxSize int64  `yaml:"max_size"`
+	MaxAge  int64  `yaml:"max_age"`
+}
+
+// TLSConfig contain tls config for log forwards
+type TLSConfig struct {
+	CA                 string `yaml:"ca"`
+	Cert               string `yaml:"cert"`
+	Key                string `yaml:"key"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// MultilineConfig contain multiline log config
+type MultilineConfig struct {
+	Start        string        `yaml:"start"`
+	Continue     string        `yaml:"continue"`
+	FlushTimeout time.Duration `yaml:"flush_timeout"`
+	MaxLines     int           `yaml:"max_lines"`
+}
+
+// RateLimitConfig contain log rate limit config
+type RateLimitConfig struct {
+	Rate           float64       `yaml:"rate"`
+	Burst          int           `yaml:"burst"`
+	Sample         int           `yaml:"sample"`
+	ReportInterval time.Duration `yaml:"report_interval"`
+}
+
+// RouteConfig send logs of matched containers to forwards
+// empty conditions match all, default means also send to hashed forward
+type RouteConfig struct {
+	Apps        []string          `yaml:"apps"`
+	EntryPoints []string          `yaml:"entrypoints"`
+	Labels      map[string]string `yaml:"labels"`
+	Forwards    []string          `yaml:"forwards"`
+	Default     bool              `yaml:"default"`
+}
+
 // LogConfig contain log config
 type LogConfig struct {
-	Forwards []string `yaml:"forwards"`
-	Stdout   bool     `yaml:"stdout"`
+	Forwards  []string                   `yaml:"forwards"`
+	Stdout    bool                       `yaml:"stdout"`
+	Spool     SpoolConfig                `yaml:"spool"`
+	TLS       TLSConfig                  `yaml:"tls"`
+	Multiline map[string]MultilineConfig `yaml:"multiline"`
+	RateLimit RateLimitConfig            `yaml:"rate_limit"`
+	Routes    []RouteConfig              `yaml:"routes"`
+}
+
+// ShutdownConfig contain graceful shutdown config
+type ShutdownConfig struct {
+	Timeout      time.Duration `yaml:"timeout"`
+	MarkNodeDown bool          `yaml:"mark_node_down"`
+}
+
+// CrashLoopConfig contain crash loop detection config
+// container restarted so many times within window is in crash loop
+// it leaves only after running for a whole window
+type CrashLoopConfig struct {
+	Window   time.Duration `yaml:"window"`
+	Restarts int           `yaml:"restarts"`
+}
+
+// ReconcileConfig contain config of reconciliation between docker and core
+type ReconcileConfig struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+// StatusConfig contain config of pushing container status to core
+// updates within batch window are sent in one call
+// unchanged status is refreshed before ttl expired, ttl defaults to 6 health check intervals
+// so unchanged status is pushed about every 5th check
+// ttl must cover health check interval and timeout, batch window and one more interval
+type StatusConfig struct {
+	BatchWindow time.Duration `yaml:"batch_window"`
+	BatchSize   int           `yaml:"batch_size"`
+	TTL         time.Duration `yaml:"ttl"`
 }
 
 // Config contain all configs
 type Config struct {
-	PidFile             string               `yaml:"pid" required:"true" default:"/tmp/agent.pid"`
-	HealthCheckInterval int                  `yaml:"health_check_interval"`
-	HealthCheckTimeout  int                  `yaml:"health_check_timeout"`
-	HealthCheckCacheTTL int                  `yaml:"health_check_cache_ttl"`
-	Core                string               `yaml:"core" required:"true"`
-	Auth                coretypes.AuthConfig `yaml:"auth"`
-	HostName            string               `yaml:"-"`
+	PidFile                     string               `yaml:"pid" required:"true" default:"/tmp/agent.pid"`
+	HealthCheckInterval         int                  `yaml:"health_check_interval"`
+	HealthCheckTimeout          int                  `yaml:"health_check_timeout"`
+	HealthCheckCacheTTL         int                  `yaml:"health_check_cache_ttl"`
+	HealthCheckFailureThreshold int                  `yaml:"health_check_failure_threshold"`
+	HealthCheckSuccessThreshold int                  `yaml:"health_check_success_threshold"`
+	HealthCheckStartPeriod      int                  `yaml:"health_check_start_period"`
+	LogLevel                    string               `yaml:"log_level"`
+	Core                        string               `yaml:"core" required:"true"`
+	Auth                        coretypes.AuthConfig `yaml:"auth"`
+	HostName                    string               `yaml:"-"`
 
 	Docker  DockerConfig
 	Metrics MetricsConfig
 	API     APIConfig
 	Log     LogConfig
+
+	Shutdown  ShutdownConfig  `yaml:"shutdown"`
+	CrashLoop CrashLoopConfig `yaml:"crashloop"`
+	Reconcile ReconcileConfig `yaml:"reconcile"`
+	Status    StatusConfig    `yaml:"status"`
+}
+
+// StatusTTL return how long status lives in core
+// ttl too small to keep status alive is raised to MinStatusTTL
+func (config *Config) StatusTTL() time.Duration {
+	ttl := time.Duration(6*config.HealthCheckInterval) * time.Second
+	if config.Status.TTL > 0 {
+		ttl = config.Status.TTL
+	}
+	if min := config.MinStatusTTL(); ttl < min {
+		return min
+	}
+	return ttl
+}
+
+// statusDelay is the longest time a refresh takes to reach core after it's due
+// it waits for next health check, the check itself and batch window
+func (config *Config) statusDelay() time.Duration {
+	return time.Duration(config.HealthCheckInterval+config.HealthCheckTimeout)*time.Second + config.Status.BatchWindow
+}
+
+// MinStatusTTL leave unchanged status at least one health check interval before refreshed
+func (config *Config) MinStatusTTL() time.Duration {
+	return config.statusDelay() + time.Duration(config.HealthCheckInterval)*time.Second
+}
+
+// StatusKeepalive return when unchanged status should be refreshed, so it arrives before ttl expired
+func (config *Config) StatusKeepalive() time.Duration {
+	return config.StatusTTL() - config.statusDelay()
 }
 
 //PrepareConfig 从cli覆写并做准备
@@ -67,6 +183,9 @@ func (config *Config) PrepareConfig(c *cli.Context) {
 	if c.String("core-password") != "" {
 		config.Auth.Password = c.String("core-password")
 	}
+	if c.IsSet("log-level") || config.LogLevel == "" {
+		config.LogLevel = c.String("log-level")
+	}
 	if c.String("pidfile") != "" {
 		config.PidFile = c.String("pidfile")
 	}
@@ -94,6 +213,9 @@ func (config *Config) PrepareConfig(c *cli.Context) {
 	if c.String("log-stdout") != "" {
 		config.Log.Stdout = c.String("log-stdout") == "yes"
 	}
+	if c.String("log-spool-dir") != "" {
+		config.Log.Spool.Dir = c.String("log-spool-dir")
+	}
 	//validate
 	if config.PidFile == "" {
 		log.Fatal("need to set pidfile")
@@ -107,4 +229,40 @@ func (config *Config) PrepareConfig(c *cli.Context) {
 	if config.HealthCheckCacheTTL == 0 {
 		config.HealthCheckCacheTTL = 60
 	}
+	if config.HealthCheckFailureThreshold == 0 {
+		config.HealthCheckFailureThreshold = 3
+	}
+	if config.HealthCheckSuccessThreshold == 0 {
+		config.HealthCheckSuccessThreshold = 1
+	}
+	if config.Log.Spool.MaxSize == 0 {
+		config.Log.Spool.MaxSize = 64 * 1024 * 1024
+	}
+	if config.Log.Spool.MaxAge == 0 {
+		config.Log.Spool.MaxAge = 86400
+	}
+	if config.Log.RateLimit.ReportInterval == 0 {
+		config.Log.RateLimit.ReportInterval = 10 * time.Second
+	}
+	if config.Shutdown.Timeout == 0 {
+		config.Shutdown.Timeout = 30 * time.Second
+	}
+	if config.CrashLoop.Window == 0 {
+		config.CrashLoop.Window = 5 * time.Minute
+	}
+	if config.CrashLoop.Restarts == 0 {
+		config.CrashLoop.Restarts = 5
+	}
+	if config.Reconcile.Interval == 0 {
+		config.Reconcile.Interval = 5 * time.Minute
+	}
+	if config.Status.BatchWindow == 0 {
+		config.Status.BatchWindow = 200 * time.Millisecond
+	}
+	if config.Status.BatchSize == 0 {
+		config.Status.BatchSize = 100
+	}
+	if config.Status.TTL > 0 && config.Status.TTL < config.MinStatusTTL() {
+		log.Warnf("status ttl %v too small for health check, %v is used", config.Status.TTL, config.MinStatusTTL())
+	}
 }
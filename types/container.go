@@ -1,21 +1,61 @@
 package types
 
 import (
+	"time"
+
 	coretypes "github.com/projecteru2/core/types"
 )
 
 // Container define agent view container
 type Container struct {
 	coretypes.StatusMeta
-	Pid         int
-	Name        string
-	EntryPoint  string
-	Ident       string
-	CPUNum      float64
-	CPUQuota    int64
-	CPUPeriod   int64
-	Memory      int64
-	Labels      map[string]string
-	HealthCheck *coretypes.HealthCheck
-	LocalIP     string `json:"-"`
+	Pid               int
+	Name              string
+	EntryPoint        string
+	Ident             string
+	CPUNum            float64
+	CPUQuota          int64
+	CPUPeriod         int64
+	Memory            int64
+	Labels            map[string]string
+	HealthCheck       *coretypes.HealthCheck
+	HealthCheckPolicy HealthCheckPolicy
+	Paused            bool
+	OOMKilled         bool
+	CrashLoop         bool
+	Restarts          int
+	ExitCode          int
+	FinishedAt        time.Time
+	Error             string
+	StartedAt         time.Time
+	LocalIP           string `json:"-"`
+}
+
+// RestartStatus restarts of container within crash loop window
+type RestartStatus struct {
+	CrashLoop bool  `json:"crashloop"`
+	Restarts  int   `json:"restarts"`
+	ExitCodes []int `json:"exit_codes"`
+}
+
+// Reconciliation result of comparing containers in docker and core
+type Reconciliation struct {
+	Time time.Time `json:"time"`
+	// status missing or stale in core, pushed again
+	Repushed []string `json:"repushed"`
+	// eru containers in docker but not in core
+	DockerOrphans []string `json:"docker_orphans"`
+	// containers in core but not in docker
+	CoreOrphans []string `json:"core_orphans"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// HealthCheckPolicy decide when health check results change container health
+// set by HealthCheck in label meta, zero means default in config
+// failures within start period after started are ignored
+type HealthCheckPolicy struct {
+	FailureThreshold int
+	SuccessThreshold int
+	// in seconds
+	StartPeriod int
 }
@@ -1,8 +1,8 @@
 package types
 
 import (
-	"bufio"
-	"net"
+	"regexp"
+	"strings"
 )
 
 // Log for log
@@ -17,10 +17,57 @@ type Log struct {
 	Extra      map[string]string `json:"extra"`
 }
 
+// LogFilter for log consumer, empty field matches all
+type LogFilter struct {
+	EntryPoint string
+	Ident      string
+	Type       string
+	ID         string
+	Grep       *regexp.Regexp
+	Extra      map[string]string
+}
+
+// Match check if log matches filter
+func (f *LogFilter) Match(log *Log) bool {
+	if f.EntryPoint != "" && f.EntryPoint != log.EntryPoint {
+		return false
+	}
+	if f.Ident != "" && f.Ident != log.Ident {
+		return false
+	}
+	if f.Type != "" && f.Type != log.Type {
+		return false
+	}
+	if f.ID != "" && !strings.HasPrefix(log.ID, f.ID) {
+		return false
+	}
+	for k, v := range f.Extra {
+		if log.Extra[k] != v {
+			return false
+		}
+	}
+	if f.Grep != nil && !f.Grep.MatchString(log.Data) {
+		return false
+	}
+	return true
+}
+
+// LogStream is the transport of a log consumer
+type LogStream interface {
+	// Send a json encoded log
+	Send([]byte) error
+	// Heartbeat keep stream alive and detect dead peer
+	Heartbeat() error
+	// Done closed when peer went away
+	Done() <-chan struct{}
+	Close() error
+}
+
 // LogConsumer for log consumer
 type LogConsumer struct {
-	ID   string
-	App  string
-	Conn net.Conn
-	Buf  *bufio.ReadWriter
+	ID     string
+	App    string
+	Stream LogStream
+	Filter LogFilter
+	Tail   int
 }
@@ -1,29 +1,99 @@
 package utils
 
-import "hash/fnv"
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"strings"
+)
 
-// HashBackends is a simple hash backend
+// virtual nodes of each backend with weight 1
+const hashReplicas = 160
+
+// HashBackends is a consistent hash ring of backends
+// backend can have a weight by suffix, e.g. udp://127.0.0.1:5144#2
 type HashBackends struct {
 	data   []string
 	length uint32
+	ring   []uint64
+	nodes  map[uint64]int
 }
 
 // NewHashBackends new a hash backends
 func NewHashBackends(data []string) *HashBackends {
-	return &HashBackends{data, uint32(len(data))}
+	s := &HashBackends{nodes: map[uint64]int{}}
+	for _, backend := range data {
+		backend, weight := parseWeight(backend)
+		index := -1
+		for i, b := range s.data {
+			if b == backend {
+				index = i
+			}
+		}
+		if index < 0 {
+			s.data = append(s.data, backend)
+			index = len(s.data) - 1
+		}
+		for i := 0; i < hashReplicas*weight; i++ {
+			h := hash(backend + "-" + strconv.Itoa(i))
+			if _, ok := s.nodes[h]; ok {
+				continue
+			}
+			s.nodes[h] = index
+			s.ring = append(s.ring, h)
+		}
+	}
+	sort.Slice(s.ring, func(i, j int) bool { return s.ring[i] < s.ring[j] })
+	s.length = uint32(len(s.data))
+	return s
 }
 
 // Get get a backend
+// offset means the offset-th different backend after the chosen one on ring, used for fail over
 func (s *HashBackends) Get(v string, offset int) string {
 	if s.length == 0 {
 		return ""
 	}
-	h := fnv.New32a()
-	h.Write([]byte(v))
-	return s.data[(h.Sum32()+uint32(offset))%s.length]
+	offset %= int(s.length)
+	h := hash(v)
+	start := sort.Search(len(s.ring), func(i int) bool { return s.ring[i] >= h })
+	if offset == 0 {
+		return s.data[s.nodes[s.ring[start%len(s.ring)]]]
+	}
+	seen := map[int]bool{}
+	for i := 0; i < len(s.ring); i++ {
+		index := s.nodes[s.ring[(start+i)%len(s.ring)]]
+		if seen[index] {
+			continue
+		}
+		if len(seen) == offset {
+			return s.data[index]
+		}
+		seen[index] = true
+	}
+	return ""
 }
 
 // Len get len of backends
 func (s *HashBackends) Len() uint32 {
 	return s.length
 }
+
+func parseWeight(backend string) (string, int) {
+	i := strings.LastIndex(backend, "#")
+	if i < 0 {
+		return backend, 1
+	}
+	weight, err := strconv.Atoi(backend[i+1:])
+	if err != nil || weight <= 0 {
+		return backend, 1
+	}
+	return backend[:i], weight
+}
+
+// fnv is not well distributed for similar keys
+func hash(v string) uint64 {
+	sum := md5.Sum([]byte(v))
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func distribute(s *HashBackends, n int) map[string]string {
+	r := map[string]string{}
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("container-%d", i)
+		r[key] = s.Get(key, 0)
+	}
+	return r
+}
+
+func TestHashBackendsDistribution(t *testing.T) {
+	s := NewHashBackends([]string{"a:1", "b:1", "c:1", "d:1#2"})
+	assert.Equal(t, uint32(4), s.Len())
+	count := map[string]int{}
+	for _, backend := range distribute(s, 10000) {
+		count[backend]++
+	}
+	// weight 1 should get 2000, weight 2 should get 4000
+	for _, backend := range []string{"a:1", "b:1", "c:1"} {
+		assert.InDelta(t, 2000, count[backend], 400, backend)
+	}
+	assert.InDelta(t, 4000, count["d:1"], 800)
+}
+
+func TestHashBackendsStability(t *testing.T) {
+	before := distribute(NewHashBackends([]string{"a:1", "b:1", "c:1"}), 10000)
+	after := distribute(NewHashBackends([]string{"a:1", "b:1", "c:1", "d:1"}), 10000)
+	moved := 0
+	for key, backend := range after {
+		if backend != before[key] {
+			moved++
+			// only moved to the new one
+			assert.Equal(t, "d:1", backend)
+		}
+	}
+	assert.InDelta(t, 2500, moved, 500)
+
+	after = distribute(NewHashBackends([]string{"a:1", "c:1"}), 10000)
+	for key, backend := range before {
+		if backend != "b:1" {
+			assert.Equal(t, backend, after[key])
+		}
+	}
+}
+
+func TestHashBackendsOffset(t *testing.T) {
+	s := NewHashBackends([]string{"a:1", "b:1", "c:1"})
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		seen[s.Get("container", i)] = true
+	}
+	assert.Len(t, seen, 3)
+	assert.Equal(t, s.Get("container", 0), s.Get("container", 3))
+
+	assert.Equal(t, "", NewHashBackends(nil).Get("container", 0))
+}
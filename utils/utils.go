@@ -49,3 +49,13 @@ func Max(a, b int64) int64 {
 	}
 	return b
 }
+
+// InStringSlice check if s in slice
+func InStringSlice(slice []string, s string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
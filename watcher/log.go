@@ -2,29 +2,120 @@ package watcher
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 
+	"github.com/projecteru2/agent/common"
 	"github.com/projecteru2/agent/types"
 )
 
+const (
+	logBufferSize     = 1024
+	consumerQueueSize = 256
+	heartbeatInterval = 30 * time.Second
+
+	// RingSize is how many lines kept for each container, also the max tail
+	RingSize = 200
+)
+
+var droppedLines = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "log_monitor_dropped_lines",
+	Help: "lines not sent to api subscribers because log monitor is busy.",
+}, []string{"appname"})
+
+func init() {
+	prometheus.MustRegister(droppedLines)
+}
+
 // Watcher indicate watcher
 type Watcher struct {
-	consumer  map[string]map[string]*types.LogConsumer
+	consumer  map[string]map[string]*consumer
+	rings     map[string]*ring
+	seq       uint64
 	LogC      chan *types.Log
 	ConsumerC chan *types.LogConsumer
+	detachC   chan *consumer
+	forgetC   chan string
+	missedMu  sync.Mutex
+	missed    map[string]int64
+}
+
+type entry struct {
+	seq uint64
+	log *types.Log
+}
+
+// ring keep last lines of a container for tail
+type ring struct {
+	app     string
+	entries []entry
+	next    int
 }
 
+func (r *ring) add(e entry) {
+	if len(r.entries) < RingSize {
+		r.entries = append(r.entries, e)
+		return
+	}
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % RingSize
+}
+
+// consumer has its own queue and writer, so a slow one won't block others
+type consumer struct {
+	*types.LogConsumer
+	queue   chan []byte
+	dropped int64
+}
+
+var errPeerGone = errors.New("peer gone")
+
 // LogMonitor indicate log monitor
 var LogMonitor *Watcher
 
 // InitMonitor init a monitor
 func InitMonitor() {
 	LogMonitor = &Watcher{}
-	LogMonitor.consumer = map[string]map[string]*types.LogConsumer{}
-	LogMonitor.LogC = make(chan *types.Log)
+	LogMonitor.consumer = map[string]map[string]*consumer{}
+	LogMonitor.rings = map[string]*ring{}
+	LogMonitor.LogC = make(chan *types.Log, logBufferSize)
 	LogMonitor.ConsumerC = make(chan *types.LogConsumer)
+	LogMonitor.detachC = make(chan *consumer)
+	LogMonitor.forgetC = make(chan string, logBufferSize)
+	LogMonitor.missed = map[string]int64{}
+}
+
+// Drop count a line not sent to LogC because it's full
+// consumers of the app are noticed with next line of it
+func (w *Watcher) Drop(log *types.Log) {
+	droppedLines.WithLabelValues(log.Name).Inc()
+	w.missedMu.Lock()
+	w.missed[log.Name]++
+	w.missedMu.Unlock()
+}
+
+// takeMissed return lines of app dropped before reaching monitor
+func (w *Watcher) takeMissed(app string) int64 {
+	w.missedMu.Lock()
+	defer w.missedMu.Unlock()
+	missed := w.missed[app]
+	delete(w.missed, app)
+	return missed
+}
+
+// Forget drop lines kept of a removed container
+func (w *Watcher) Forget(ID string) {
+	select {
+	case w.forgetC <- ID:
+	default:
+	}
 }
 
 // Serve start monitor
@@ -33,33 +124,130 @@ func (w *Watcher) Serve() {
 	for {
 		select {
 		case log := <-w.LogC:
-			if consumers, ok := w.consumer[log.Name]; ok {
-				data, err := json.Marshal(log)
-				if err != nil {
-					logrus.Error(err)
-					break
+			w.keep(log)
+			missed := w.takeMissed(log.Name)
+			consumers, ok := w.consumer[log.Name]
+			if !ok {
+				break
+			}
+			var data []byte
+			for _, c := range consumers {
+				// filter unknown for lines missed, count them anyway
+				atomic.AddInt64(&c.dropped, missed)
+				if !c.Filter.Match(log) {
+					continue
 				}
-				line := fmt.Sprintf("%X\r\n%s\r\n\r\n", len(data)+2, string(data))
-				for id, consumer := range consumers {
-					if _, err := consumer.Buf.WriteString(line); err != nil {
+				if data == nil {
+					var err error
+					if data, err = json.Marshal(log); err != nil {
 						logrus.Error(err)
-						logrus.Infof("%s %s log detached", consumer.App, consumer.ID)
-						consumer.Conn.Close()
-						delete(consumers, id)
-						if len(w.consumer[log.Name]) == 0 {
-							delete(w.consumer, log.Name)
-						}
+						break
 					}
-					consumer.Buf.Flush()
 				}
+				// never wait for consumer
+				select {
+				case c.queue <- data:
+				default:
+					atomic.AddInt64(&c.dropped, 1)
+				}
+			}
+		case logConsumer := <-w.ConsumerC:
+			c := &consumer{
+				LogConsumer: logConsumer,
+				queue:       make(chan []byte, consumerQueueSize),
 			}
-		case consumer := <-w.ConsumerC:
-			if consumers, ok := w.consumer[consumer.App]; ok {
-				consumers[consumer.ID] = consumer
-			} else {
-				w.consumer[consumer.App] = map[string]*types.LogConsumer{}
-				w.consumer[consumer.App][consumer.ID] = consumer
+			w.replay(c)
+			if _, ok := w.consumer[c.App]; !ok {
+				w.consumer[c.App] = map[string]*consumer{}
 			}
+			w.consumer[c.App][c.ID] = c
+			go c.run(w.detachC)
+		case ID := <-w.forgetC:
+			delete(w.rings, ID)
+		case c := <-w.detachC:
+			logrus.Infof("%s %s log detached", c.App, c.ID)
+			c.Stream.Close()
+			delete(w.consumer[c.App], c.ID)
+			if len(w.consumer[c.App]) == 0 {
+				delete(w.consumer, c.App)
+			}
+		}
+	}
+}
+
+func (w *Watcher) keep(log *types.Log) {
+	r, ok := w.rings[log.ID]
+	if !ok {
+		r = &ring{app: log.Name}
+		w.rings[log.ID] = r
+	}
+	w.seq++
+	r.add(entry{seq: w.seq, log: log})
+}
+
+// replay last lines of app to consumer before new lines
+func (w *Watcher) replay(c *consumer) {
+	if c.Tail <= 0 {
+		return
+	}
+	entries := []entry{}
+	for _, r := range w.rings {
+		if r.app != c.App {
+			continue
+		}
+		for _, e := range r.entries {
+			if c.Filter.Match(e.log) {
+				entries = append(entries, e)
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+	if len(entries) > c.Tail {
+		entries = entries[len(entries)-c.Tail:]
+	}
+	for _, e := range entries {
+		data, err := json.Marshal(e.log)
+		if err != nil {
+			logrus.Error(err)
+			continue
+		}
+		select {
+		case c.queue <- data:
+		default:
+			atomic.AddInt64(&c.dropped, 1)
 		}
 	}
 }
+
+func (c *consumer) run(detachC chan<- *consumer) {
+	tick := time.NewTicker(heartbeatInterval)
+	defer tick.Stop()
+	var err error
+	for err == nil {
+		select {
+		case data := <-c.queue:
+			if dropped := atomic.SwapInt64(&c.dropped, 0); dropped > 0 {
+				if err = c.Stream.Send(c.notice(dropped)); err != nil {
+					break
+				}
+			}
+			err = c.Stream.Send(data)
+		case <-tick.C:
+			err = c.Stream.Heartbeat()
+		case <-c.Stream.Done():
+			err = errPeerGone
+		}
+	}
+	logrus.Debugf("[logServe] %s %s stream stopped %v", c.App, c.ID, err)
+	detachC <- c
+}
+
+func (c *consumer) notice(dropped int64) []byte {
+	data, _ := json.Marshal(&types.Log{
+		Name:     c.App,
+		Type:     "agent",
+		Data:     fmt.Sprintf("%d lines dropped, monitor or consumer too slow", dropped),
+		Datetime: time.Now().Format(common.DateTimeFormat),
+	})
+	return data
+}
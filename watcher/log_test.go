@@ -0,0 +1,181 @@
+package watcher
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/projecteru2/agent/types"
+)
+
+type pipeStream struct {
+	conn net.Conn
+	done chan struct{}
+}
+
+func (s *pipeStream) Send(data []byte) error {
+	s.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_, err := s.conn.Write(append(data, '\n'))
+	return err
+}
+
+func (s *pipeStream) Heartbeat() error {
+	return nil
+}
+
+func (s *pipeStream) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *pipeStream) Close() error {
+	return s.conn.Close()
+}
+
+func newTestConsumer(ID, app string) (*types.LogConsumer, net.Conn) {
+	server, client := net.Pipe()
+	stream := &pipeStream{conn: server, done: make(chan struct{})}
+	return &types.LogConsumer{ID: ID, App: app, Stream: stream}, client
+}
+
+func TestSlowConsumer(t *testing.T) {
+	InitMonitor()
+	go LogMonitor.Serve()
+
+	slow, slowClient := newTestConsumer("slow", "app")
+	defer slowClient.Close()
+	fast, fastClient := newTestConsumer("fast", "app")
+	defer fastClient.Close()
+	LogMonitor.ConsumerC <- slow
+	LogMonitor.ConsumerC <- fast
+
+	received := make(chan string, 1)
+	go func() {
+		reader := bufio.NewReader(fastClient)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(line, "{") {
+				select {
+				case received <- line:
+				default:
+				}
+			}
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 2000; i++ {
+			LogMonitor.LogC <- &types.Log{Name: "app", Data: "data"}
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("blocked by slow consumer")
+	}
+	select {
+	case line := <-received:
+		assert.Contains(t, line, `"name":"app"`)
+	case <-time.After(3 * time.Second):
+		t.Fatal("fast consumer got nothing")
+	}
+}
+
+func TestTailAndFilter(t *testing.T) {
+	InitMonitor()
+	go LogMonitor.Serve()
+
+	for i := 0; i < 5; i++ {
+		LogMonitor.LogC <- &types.Log{ID: "c1", Name: "app", Type: "stdout", Data: fmt.Sprintf("out %d", i)}
+		LogMonitor.LogC <- &types.Log{ID: "c2", Name: "app", Type: "stderr", Data: fmt.Sprintf("err %d", i)}
+	}
+	LogMonitor.LogC <- &types.Log{ID: "c3", Name: "other", Type: "stderr", Data: "err"}
+	// wait for buffered lines kept
+	time.Sleep(100 * time.Millisecond)
+
+	c, client := newTestConsumer("tail", "app")
+	defer client.Close()
+	c.Tail = 3
+	c.Filter = types.LogFilter{Type: "stderr", Grep: regexp.MustCompile(`err [0-3]`)}
+	LogMonitor.ConsumerC <- c
+	LogMonitor.LogC <- &types.Log{ID: "c1", Name: "app", Type: "stdout", Data: "err 1"}
+	LogMonitor.LogC <- &types.Log{ID: "c2", Name: "app", Type: "stderr", Data: "err 2"}
+
+	reader := bufio.NewReader(client)
+	lines := []string{}
+	for len(lines) < 4 {
+		line, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		if strings.HasPrefix(line, "{") {
+			l := &types.Log{}
+			assert.NoError(t, json.Unmarshal([]byte(line), l))
+			lines = append(lines, l.Data)
+		}
+	}
+	assert.Equal(t, []string{"err 1", "err 2", "err 3", "err 2"}, lines)
+}
+
+func TestForget(t *testing.T) {
+	InitMonitor()
+	go LogMonitor.Serve()
+
+	LogMonitor.LogC <- &types.Log{ID: "c1", Name: "app", Type: "stdout", Data: "gone"}
+	LogMonitor.LogC <- &types.Log{ID: "c2", Name: "app", Type: "stdout", Data: "kept"}
+	time.Sleep(100 * time.Millisecond)
+	LogMonitor.Forget("c1")
+
+	c, client := newTestConsumer("tail", "app")
+	defer client.Close()
+	c.Tail = 10
+	LogMonitor.ConsumerC <- c
+
+	reader := bufio.NewReader(client)
+	for {
+		line, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		if strings.HasPrefix(line, "{") {
+			l := &types.Log{}
+			assert.NoError(t, json.Unmarshal([]byte(line), l))
+			assert.Equal(t, "kept", l.Data)
+			return
+		}
+	}
+}
+
+func TestDropNotice(t *testing.T) {
+	InitMonitor()
+	go LogMonitor.Serve()
+
+	c, client := newTestConsumer("drop", "app")
+	defer client.Close()
+	LogMonitor.ConsumerC <- c
+	for i := 0; i < 3; i++ {
+		LogMonitor.Drop(&types.Log{Name: "app", Data: "lost"})
+	}
+	LogMonitor.Drop(&types.Log{Name: "other", Data: "lost"})
+	LogMonitor.LogC <- &types.Log{Name: "app", Data: "kept"}
+
+	reader := bufio.NewReader(client)
+	lines := []string{}
+	for len(lines) < 2 {
+		line, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		l := &types.Log{}
+		assert.NoError(t, json.Unmarshal([]byte(line), l))
+		lines = append(lines, l.Data)
+	}
+	assert.Equal(t, "3 lines dropped, monitor or consumer too slow", lines[0])
+	assert.Equal(t, "kept", lines[1])
+}